@@ -1,14 +1,40 @@
 package browser
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
+	"path"
+	"runtime"
+	"strings"
 
 	"spamd/internal/sys"
 )
 
 const (
-	defaultMarkdown = "README.md"
+	// Prefix of base URLs that are paths to unix sockets.
+	unix_prefix = "unix:"
+
+	// Placeholders in browser command templates, replaced by the URL
+	// of a markdown (one command per markdown), or by the URLs of all
+	// of them (a single command, e.g. to open them in one window).
+	url_placeholder  = "{url}"
+	urls_placeholder = "{urls}"
+)
+
+var (
+	// Opened if no files are given, in order of preference.
+	// Each is matched case-insensitively.
+	defaultMarkdowns = []string{
+		"README.md",
+		"README.markdown",
+		"README",
+		"index.md",
+		"docs/README.md",
+	}
 )
 
 type BrowserDelegate struct {
@@ -19,6 +45,143 @@ func (b BrowserDelegate) Start() error {
 	return b.cmd.Start()
 }
 
+// Browser opens URLs with the command template set in the config (or
+// -browser), e.g. "firefox --new-window {urls}". If the template is
+// empty, $BROWSER is used, and then the default browser of the platform.
+type Browser struct {
+	Template string
+}
+
+func New(template string) Browser {
+	return Browser{Template: template}
+}
+
+// Returns the templates to try in order. $BROWSER may list several,
+// separated by colons, and uses %s as the placeholder.
+func (b Browser) templates() []string {
+	if b.Template != "" {
+		return []string{b.Template}
+	}
+
+	env := os.Getenv("BROWSER")
+	if env == "" {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		// Colons are part of paths, e.g. C:\.
+		return []string{strings.ReplaceAll(env, "%s", url_placeholder)}
+	}
+
+	var templates []string
+	for _, template := range strings.Split(env, ":") {
+		if template = strings.TrimSpace(template); template != "" {
+			templates = append(templates, strings.ReplaceAll(template, "%s", url_placeholder))
+		}
+	}
+	return templates
+}
+
+// Returns the command lines that open urls with template. Without a
+// placeholder, the URL is appended to the template.
+func commandLines(template string, urls []string) [][]string {
+	fields := strings.Fields(template)
+	if len(fields) == 0 || len(urls) == 0 {
+		return nil
+	}
+
+	if !strings.Contains(template, url_placeholder) && !strings.Contains(template, urls_placeholder) {
+		fields = append(fields, url_placeholder)
+	}
+
+	if strings.Contains(template, urls_placeholder) {
+		var line []string
+		for _, field := range fields {
+			if field == urls_placeholder {
+				line = append(line, urls...)
+			} else {
+				line = append(line, strings.ReplaceAll(field, urls_placeholder, strings.Join(urls, " ")))
+			}
+		}
+		return [][]string{line}
+	}
+
+	var lines [][]string
+	for _, url := range urls {
+		line := make([]string, len(fields))
+		for i, field := range fields {
+			line[i] = strings.ReplaceAll(field, url_placeholder, url)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Starts cmd and reports (in the log) if it exits with an error,
+// e.g. xdg-open without a display.
+func start(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			slog.Warn("The browser exited with an error", "command", cmd.String(), "error", err)
+		}
+	}()
+	return nil
+}
+
+// Opens urls with the first command found of b.templates(), or with
+// the default browser of the platform.
+func (b Browser) Open(urls ...string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	// Templates whose command is not installed, reported only if no
+	// browser is found.
+	var missing []error
+	for _, template := range b.templates() {
+		lines := commandLines(template, urls)
+		if len(lines) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(lines[0][0]); err != nil {
+			missing = append(missing, err)
+			continue
+		}
+
+		var errs []error
+		for _, line := range lines {
+			if err := start(exec.Command(line[0], line[1:]...)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	if b.Template != "" {
+		return errors.Join(missing...)
+	}
+
+	delegates := Commands("")
+	if _, ok := delegates[runtime.GOOS]; !ok {
+		return errors.Join(append(missing, fmt.Errorf("No default browser on %s. Set \"browser\" (or -browser) to the command opening URLs.", runtime.GOOS))...)
+	}
+
+	var errs []error
+	for _, url := range urls {
+		delegate := Commands(url)[runtime.GOOS].(BrowserDelegate)
+		if err := start(delegate.cmd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(urls) {
+		// None started, so $BROWSER was no use either.
+		errs = append(missing, errs...)
+	}
+	return errors.Join(errs...)
+}
+
 func Commands(url string) sys.Commands {
 	return sys.Commands{
 		"linux":   BrowserDelegate{cmd: exec.Command("xdg-open", url)},
@@ -27,27 +190,62 @@ func Commands(url string) sys.Commands {
 	}
 }
 
-func MassOpen(baseUrl string, nobrowser bool) {
-	var filepath string = defaultMarkdown
+// Returns the path to the first of defaultMarkdowns found in the
+// current directory (case-insensitively), or "" if there is none.
+func findDefaultMarkdown() string {
+	for _, candidate := range defaultMarkdowns {
+		dir, name := path.Split(candidate)
+		entries, err := os.ReadDir("./" + dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(entry.Name(), name) {
+				return dir + entry.Name()
+			}
+		}
+	}
+
+	return ""
+}
+
+func MassOpen(b Browser, baseUrl string, nobrowser bool, isMarkdown func(string) bool) {
+	// Servers at unix sockets are reached through a proxy, which
+	// browsers cannot be pointed at from here.
+	if strings.HasPrefix(baseUrl, unix_prefix) {
+		fmt.Printf("Listening at unix socket %s.\n", strings.TrimPrefix(baseUrl, unix_prefix))
+		nobrowser = true
+	}
+
+	var urls []string
 	if flag.NArg() >= 1 {
 		for i := 0; i < len(flag.Args()); i++ {
 			filepath := flag.Args()[i]
 
-			if !sys.IsFileWithExt(filepath, ".md") {
-				sys.Eprintf("%s is not a markdown document.\n", filepath)
-			} else if !sys.Exists(filepath) {
-				sys.Eprintf("%s does not exist.\n", filepath)
-			} else {
-				if !nobrowser {
-					go func() {
-						sys.Exec(Commands(baseUrl + "/" + filepath))
-					}()
+			if !sys.IsFile(filepath) || !isMarkdown(filepath) {
+				if !sys.Exists(filepath) {
+					sys.Eprintf("%s does not exist.\n", filepath)
+				} else {
+					sys.Eprintf("%s is not a markdown document.\n", filepath)
 				}
+			} else {
+				urls = append(urls, baseUrl+"/"+filepath)
 			}
 		}
-	} else {
-		if !nobrowser && sys.IsFileWithExt(filepath, ".md") && sys.Exists(filepath) {
-			sys.Exec(Commands(baseUrl + "/" + filepath))
-		}
+	} else if filepath := findDefaultMarkdown(); filepath != "" {
+		urls = append(urls, baseUrl+"/"+filepath)
+	}
+
+	if !nobrowser {
+		OpenOrReport(b, urls...)
+	}
+}
+
+// Opens urls with b, printing them if the browser fails to start so
+// that they can be opened by hand.
+func OpenOrReport(b Browser, urls ...string) {
+	if err := b.Open(urls...); err != nil {
+		sys.Eprintf("Failed to open the browser: %s\nOpen %s instead.\n", err, strings.Join(urls, " "))
 	}
 }
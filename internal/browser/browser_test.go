@@ -0,0 +1,89 @@
+package browser
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandLines(t *testing.T) {
+	urls := []string{"http://localhost:3000/a.md", "http://localhost:3000/b.md"}
+	cases := []struct {
+		template string
+		want     [][]string
+	}{
+		{"firefox", [][]string{{"firefox", urls[0]}, {"firefox", urls[1]}}},
+		{"firefox --new-tab {url}", [][]string{{"firefox", "--new-tab", urls[0]}, {"firefox", "--new-tab", urls[1]}}},
+		{"chromium --app={url}", [][]string{{"chromium", "--app=" + urls[0]}, {"chromium", "--app=" + urls[1]}}},
+		{"firefox -P docs --new-window {urls}", [][]string{{"firefox", "-P", "docs", "--new-window", urls[0], urls[1]}}},
+		{"  ", nil},
+	}
+
+	for _, c := range cases {
+		if got := commandLines(c.template, urls); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v; want %v", c.template, got, c.want)
+		}
+	}
+}
+
+func TestTemplatesFromEnv(t *testing.T) {
+	t.Setenv("BROWSER", "w3m %s:firefox")
+
+	got := New("").templates()
+	want := []string{"w3m {url}", "firefox"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// The config takes precedence over $BROWSER.
+	if got := New("chromium").templates(); !reflect.DeepEqual(got, []string{"chromium"}) {
+		t.Errorf("got %v; want [chromium]", got)
+	}
+}
+
+func TestOpenReportsMissingBrowser(t *testing.T) {
+	err := New("spamd-no-such-browser {url}").Open("http://localhost:3000/README.md")
+	if err == nil || !strings.Contains(err.Error(), "spamd-no-such-browser") {
+		t.Errorf("got %v; want an error naming the browser", err)
+	}
+}
+
+func TestOpenStartsBrowser(t *testing.T) {
+	if err := New("true {urls}").Open("http://localhost:3000/README.md"); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+}
+
+func TestOpenSkipsMissingBrowsersInEnv(t *testing.T) {
+	t.Setenv("BROWSER", "spamd-no-such-browser %s:true")
+
+	if err := New("").Open("http://localhost:3000/README.md"); err != nil {
+		t.Errorf("Should not return error. Got error \"%s\"", err)
+	}
+}
+
+func TestFindDefaultMarkdown(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+
+	cases := []struct {
+		files    []string
+		expected string
+	}{
+		{[]string{"readme.md", "index.md"}, "readme.md"},
+		{[]string{"README", "index.md"}, "README"},
+		{[]string{"Readme.markdown", "README"}, "Readme.markdown"},
+		{[]string{"notes.md"}, ""},
+	}
+
+	for _, c := range cases {
+		os.Chdir(t.TempDir())
+		for _, file := range c.files {
+			os.WriteFile(file, []byte("# Title\n"), 0644)
+		}
+		if got := findDefaultMarkdown(); got != c.expected {
+			t.Errorf("%v: got %q; want %q", c.files, got, c.expected)
+		}
+	}
+}
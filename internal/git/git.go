@@ -0,0 +1,74 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Runs git with args in the current directory and returns its
+// standard output. The error includes whatever git printed to
+// standard error.
+func run(args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], msg)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Revisions starting with '-' would be read as options by git.
+func validRef(ref string) error {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("Invalid revision \"%s\".", ref)
+	}
+
+	return nil
+}
+
+// Returns the commit hash that ref (e.g. "HEAD", "main",
+// or an abbreviated hash) points to.
+func ResolveRef(ref string) (string, error) {
+	if err := validRef(ref); err != nil {
+		return "", err
+	}
+
+	out, err := run("rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Unknown revision \"%s\".", ref)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Returns the contents of the file at path (relative to the current
+// directory) in revision ref, read from the repository's object store.
+//
+// The error wraps os.ErrNotExist if ref is valid but the file
+// does not exist in it.
+func Show(ref string, path string) ([]byte, error) {
+	if err := validRef(ref); err != nil {
+		return nil, err
+	}
+
+	data, err := run("cat-file", "blob", ref+":./"+path)
+	if err != nil {
+		if _, refErr := ResolveRef(ref); refErr != nil {
+			return nil, refErr
+		}
+		return nil, fmt.Errorf("%s does not exist at %s: %w", path, ref, os.ErrNotExist)
+	}
+
+	return data, nil
+}
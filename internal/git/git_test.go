@@ -0,0 +1,88 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// Creates a repository in a temporary directory with a single commit
+// and changes into it. Returns a function restoring the working directory.
+func setupRepo(t *testing.T) func() {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed.")
+	}
+
+	cwd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Mkdir("docs", 0777)
+	os.WriteFile("docs/README.md", []byte("# Committed"), 0666)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "first"},
+	} {
+		if _, err := run(args...); err != nil {
+			os.Chdir(cwd)
+			t.Fatal(err)
+		}
+	}
+
+	// Working copy differs from the commit.
+	os.WriteFile("docs/README.md", []byte("# Modified"), 0666)
+
+	return func() { os.Chdir(cwd) }
+}
+
+func TestShowFileAtRevision(t *testing.T) {
+	defer setupRepo(t)()
+
+	got, err := Show("HEAD", "docs/README.md")
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if string(got) != "# Committed" {
+		t.Errorf("got %s; want # Committed", got)
+	}
+
+	// Relative to the current directory.
+	os.Chdir("docs")
+	got, _ = Show("HEAD", "README.md")
+	if string(got) != "# Committed" {
+		t.Errorf("got %s; want # Committed", got)
+	}
+}
+
+func TestShowMissingFile(t *testing.T) {
+	defer setupRepo(t)()
+
+	_, err := Show("HEAD", "no-such-file.md")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v; want os.ErrNotExist", err)
+	}
+}
+
+func TestInvalidRevision(t *testing.T) {
+	defer setupRepo(t)()
+
+	for _, ref := range []string{"no-such-branch", "--output=x", ""} {
+		_, err := Show(ref, "docs/README.md")
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Show(%q): got %v; want invalid revision error", ref, err)
+		}
+	}
+}
+
+func TestResolveRef(t *testing.T) {
+	defer setupRepo(t)()
+
+	sha, err := ResolveRef("HEAD")
+	if err != nil || len(sha) != 40 {
+		t.Errorf("got %s (%v); want commit hash", sha, err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package instance records the running spamd servers in the user's
+// runtime directory (one JSON lockfile per server), so that other
+// invocations can find and control them.
+package instance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const lockfile_ext = ".json"
+
+type Instance struct {
+	PID int `json:"pid"`
+	// Base URL of the server, e.g. "http://127.0.0.1:3000".
+	Address string `json:"address"`
+	// Working directory of the server, which files are served from.
+	Dir string `json:"dir"`
+	// Sent in requests that control the server (e.g. to stop it).
+	Token   string    `json:"token"`
+	Version string    `json:"version"`
+	Started time.Time `json:"started"`
+}
+
+// Returns the directory where lockfiles are kept: spamd under
+// $XDG_RUNTIME_DIR, or spamd-<uid> under the temporary directory.
+func Dir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "spamd")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("spamd-%d", os.Getuid()))
+}
+
+// Returns an error unless dir is a directory (not a symlink) owned by
+// the current user and only accessible by them. Otherwise, another user
+// could have created it first (e.g. in a shared /tmp) to plant lockfiles
+// of their own servers.
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory.", dir)
+	}
+	if !ownedByCurrentUser(info) || info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s must be owned by the current user with permissions 0700.", dir)
+	}
+
+	return nil
+}
+
+func lockfile(pid int) string {
+	return filepath.Join(Dir(), fmt.Sprintf("%d%s", pid, lockfile_ext))
+}
+
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Writes the lockfile of inst, readable by the current user only.
+func Register(inst Instance) error {
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return err
+	}
+	if err := checkDir(Dir()); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(lockfile(inst.PID), data, 0600)
+}
+
+// Removes the lockfile of the server with pid.
+func Unregister(pid int) error {
+	err := os.Remove(lockfile(pid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Returns true if the process with pid is known to have exited.
+// Processes that cannot be signalled (e.g. of another user) are
+// assumed to be running.
+func Exited(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		// Only fails if there is no such process (on Windows).
+		return true
+	}
+
+	err = p.Signal(syscall.Signal(0))
+	return errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH)
+}
+
+// Returns the recorded servers, oldest first. Servers that are no
+// longer running may still be recorded if they did not shut down.
+func List() ([]Instance, error) {
+	err := checkDir(Dir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(Dir())
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), lockfile_ext) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(Dir(), entry.Name()))
+		if err != nil {
+			continue
+		}
+		var inst Instance
+		if err := json.Unmarshal(data, &inst); err != nil {
+			continue
+		}
+		instances = append(instances, inst)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Started.Before(instances[j].Started)
+	})
+	return instances, nil
+}
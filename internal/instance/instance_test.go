@@ -0,0 +1,84 @@
+package instance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegisterAndList(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	first := Instance{PID: 20, Address: "http://127.0.0.1:3000", Dir: "/docs", Token: "a", Started: time.Unix(100, 0)}
+	second := Instance{PID: 10, Address: "http://127.0.0.1:3001", Dir: "/notes", Token: "b", Started: time.Unix(200, 0)}
+	for _, inst := range []Instance{second, first} {
+		if err := Register(inst); err != nil {
+			t.Fatalf("Should not return error. Got error \"%s\"", err)
+		}
+	}
+
+	info, _ := os.Stat(lockfile(first.PID))
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("got %o; want 600", mode)
+	}
+
+	// Other files are ignored.
+	os.WriteFile(filepath.Join(Dir(), "30.json"), []byte("{"), 0600)
+
+	instances, err := List()
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if len(instances) != 2 || instances[0].Dir != "/docs" || instances[1].Dir != "/notes" {
+		t.Errorf("got %v; want %v and %v", instances, first, second)
+	}
+
+	Unregister(first.PID)
+	if instances, _ := List(); len(instances) != 1 || instances[0].PID != second.PID {
+		t.Errorf("got %v; want %v", instances, second)
+	}
+}
+
+func TestListWithoutDir(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(t.TempDir(), "missing"))
+
+	instances, err := List()
+	if err != nil || len(instances) != 0 {
+		t.Errorf("got %v, %v; want no instances", instances, err)
+	}
+}
+
+func TestExited(t *testing.T) {
+	if Exited(os.Getpid()) {
+		t.Errorf("got true; want false for the current process")
+	}
+	// Above the largest PID on Linux (and macOS).
+	if !Exited(1 << 30) {
+		t.Errorf("got false; want true for a process that does not exist")
+	}
+}
+
+func TestRefuseUnsafeDir(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	inst := Instance{PID: 10, Address: "http://127.0.0.1:3000", Dir: "/docs"}
+
+	// Created by someone else with other permissions.
+	os.Mkdir(Dir(), 0755)
+	os.Chmod(Dir(), 0755)
+	if err := Register(inst); err == nil {
+		t.Error("got <nil>; want error on a directory accessible by others")
+	}
+	if _, err := List(); err == nil {
+		t.Error("got <nil>; want error on a directory accessible by others")
+	}
+
+	os.Remove(Dir())
+	target := t.TempDir()
+	os.Chmod(target, 0700)
+	os.Symlink(target, Dir())
+	if err := Register(inst); err == nil {
+		t.Error("got <nil>; want error on a symlink")
+	}
+}
@@ -0,0 +1,38 @@
+// Package logging sets up the default logger (log/slog), through which
+// the log package is also written.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// Makes the default logger write records of at least level ("debug",
+// "info", "warn" or "error") to w in format (TextFormat or JSONFormat).
+func Setup(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("Unknown log level %s. Use debug, info, warn or error.", level)
+	}
+
+	options := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case TextFormat:
+		handler = slog.NewTextHandler(w, options)
+	case JSONFormat:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("Unknown log format %s. Use text or json.", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestSetupJSON(t *testing.T) {
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	var out bytes.Buffer
+	if err := Setup(&out, "warn", "json"); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	slog.Info("Dropped")
+	slog.Warn("Kept", "file", "README.md")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("got %s; want a single JSON record", out.String())
+	}
+	if record["level"] != "WARN" || record["msg"] != "Kept" || record["file"] != "README.md" {
+		t.Errorf("got %v; want the warning only", record)
+	}
+
+	// The log package goes through the same logger (at info level).
+	out.Reset()
+	log.Println("Dropped too")
+	if out.Len() > 0 {
+		t.Errorf("got %s; want nothing below warn", out.String())
+	}
+}
+
+func TestSetupInvalid(t *testing.T) {
+	if err := Setup(&bytes.Buffer{}, "verbose", "text"); err == nil {
+		t.Error("got <nil>; want error on unknown level")
+	}
+	if err := Setup(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("got <nil>; want error on unknown format")
+	}
+}
@@ -2,31 +2,135 @@ package options
 
 import (
 	"flag"
+	"os"
 
 	"spamd/internal/sys"
 )
 
 const (
-	beginUsage = "Usage: spamd [options...] <path-to-markdown>\nOptions:"
+	beginUsage = "Usage: spamd [options...] <path-to-markdown | ->\nOptions:"
 	endUsage   = `Additionally, if you want to persist any of this configs, you can
 create a .spamd JSON file at your ROOT directory containing:
 
 	{
 	  "theme": "dark",
 	  "codeblock": "fruity",
-	  "port": 3000
+	  "port": 3000,
+	  "port_fallback": true,
+	  "listen": "unix:/run/spamd/docs.sock",
+	  "socket_mode": "0660",
+	  "base_path": "/preview/docs",
+	  "trust_proxy": true,
+	  "safe": true,
+	  "metrics": true,
+	  "browser": "firefox --new-window {urls}",
+	  "renderer": "github",
+	  "renderer_url": "https://api.github.com",
+	  "markdown_extensions": [".md", ".markdown", ".mdx", ".mkd"],
+	  "extensions": {
+	    "unsafe": false,
+	    "definitionlist": true
+	  }
 	}
 
 This is just an example. You can change/omit any of the fields.
+
+The "extensions" section toggles markdown extensions on/off. Available:
+table, strikethrough, linkify, tasklist, footnote, highlighting, emoji,
+unsafe (raw HTML), autoheadingid (all on by default) and definitionlist,
+typographer, cjk, attribute (all off by default).
+
+The server status (watched files, open tabs, last render, render cache
+hits) is served as JSON at /__/status, and Prometheus metrics at
+/__/metrics if "metrics" (or -metrics) is set.
+
+If "port" is busy, spamd opens the markdowns in the spamd using it (if
+it runs in the same directory). Otherwise, "port_fallback" (or
+-port-fallback) uses the next free port instead of failing.
+
+"listen" serves at a unix socket instead of "port" (e.g. behind a
+reverse proxy), with the permissions in "socket_mode" (default: 0600).
+"base_path" (or -base-path) is the path the proxy serves spamd at. The
+X-Forwarded-Prefix, -Host, -Proto and -For headers of the proxy are
+honored if "listen" or "base_path" is set, or "trust_proxy" (or
+-trust-proxy) for a proxy in front of "port". Otherwise, they are
+ignored.
+
+"browser" (or -browser) is the command opening the markdowns, with
+{url} replaced by the URL of each, or {urls} by all of them (e.g.
+"firefox --new-window {urls}" opens them in a single window). It
+defaults to $BROWSER, and then to the default browser.
+
+"markdown_extensions" lists the file extensions opened as markdowns
+(matched case-insensitively). A README without extension is always one.
+If no path is given, the first of README.md, README.markdown, README,
+index.md and docs/README.md found (in any case) is opened.
+
+With - as the path, markdown is read from stdin and previewed live as
+more of it arrives, e.g. "tail -f notes.md | spamd -".
+
+If a server is already running in the current directory, the markdowns
+are opened there (unless -new is set). "spamd list" shows the running
+servers and "spamd stop" stops the one in the current directory.
 `
 )
 
+const (
+	// Writes markdowns as PDFs, e.g. "spamd export --pdf README.md".
+	ExportCommand = "export"
+	// Prints the HTML of a markdown, e.g. "spamd render README.md > out.html".
+	RenderCommand = "render"
+	// Lists the running servers.
+	ListCommand = "list"
+	// Stops the server running in the current directory (or all of them).
+	StopCommand = "stop"
+)
+
 type Options struct {
-	ShowVersion bool
-	NoBrowser   bool
-	Port        int
-	Theme       string
-	CodeStyle   string
+	// One of the commands above, if given instead of markdowns.
+	Command string
+	Export  ExportOptions
+	Render  RenderOptions
+	Stop    StopOptions
+
+	// Previews markdown read from stdin (given - as the path).
+	Stdin bool
+
+	ShowVersion  bool
+	NoBrowser    bool
+	New          bool // Even if a server is running in the current directory.
+	Port         int
+	PortFallback bool
+	Listen       string
+	SocketMode   string
+	BasePath     string
+	TrustProxy   bool
+	Browser      string
+	Theme        string
+	CodeStyle    string
+	Safe         bool
+	Metrics      bool
+	Renderer     string
+	LogLevel     string
+	LogFormat    string
+}
+
+type ExportOptions struct {
+	PDF      bool
+	Output   string
+	PageSize string
+	Files    []string
+}
+
+type RenderOptions struct {
+	// Prints a full page (with the styles embedded) instead of a fragment.
+	Full bool
+	// The file to render, or - for stdin.
+	File string
+}
+
+type StopOptions struct {
+	All bool
 }
 
 func ParseOptions() *Options {
@@ -34,13 +138,92 @@ func ParseOptions() *Options {
 	flag.BoolVar(&options.ShowVersion, "v", false, "Display version and exit")
 	flag.BoolVar(&options.NoBrowser, "nb", false, "Do not open browser if this is set true (default: false)")
 	flag.IntVar(&options.Port, "p", 0, "Port number (fixed port, otherwise a RANDOM port is supplied)")
+	flag.BoolVar(&options.PortFallback, "port-fallback", false, "If the port is busy, use the next free one (or a random one)")
+	flag.StringVar(&options.Listen, "listen", "", "Listen at a unix socket (\"unix:/path/to.sock\") instead of a TCP port. No browser is opened.")
+	flag.StringVar(&options.SocketMode, "socket-mode", "", "Permissions of the unix socket (default: 0600)")
+	flag.StringVar(&options.BasePath, "base-path", "", "Path the server is mounted at behind a reverse proxy, e.g. \"/preview/alice\"")
+	flag.BoolVar(&options.TrustProxy, "trust-proxy", false, "Honor the X-Forwarded-* headers of a reverse proxy (implied by -listen and -base-path)")
+	flag.StringVar(&options.Browser, "browser", "", "Command opening the markdowns, e.g. \"firefox --new-window {urls}\" (default: $BROWSER, or the default browser)")
 	flag.StringVar(&options.Theme, "t", "", "Display markdown HTML in \"dark\" or \"light\" theme. (default: light)")
 	flag.StringVar(&options.CodeStyle, "c", "", "The style you want to apply to your code blocks. (default: monokai)")
+	flag.StringVar(&options.Renderer, "r", "", "Render markdowns \"local\"ly or with the \"github\" API, for previews identical to Github. (default: local)")
+	flag.BoolVar(&options.Safe, "safe", false, "Sanitize raw HTML (e.g. <script>) the way Github does. Use this to preview untrusted markdowns.")
+	flag.BoolVar(&options.New, "new", false, "Start a new server even if one is already running in this directory")
+	flag.BoolVar(&options.Metrics, "metrics", false, "Serve Prometheus metrics at /__/metrics")
+	flag.StringVar(&options.LogLevel, "log-level", "info", "Log \"debug\", \"info\", \"warn\" or \"error\" messages and above")
+	flag.StringVar(&options.LogFormat, "log-format", "text", "Write logs as \"text\" or \"json\"")
 	flag.Usage = func() {
 		sys.Eprintf("%s\n\n", beginUsage)
 		flag.PrintDefaults()
 		sys.Eprintf("\n%s", endUsage)
 	}
 	flag.Parse()
+
+	options.Stdin = flag.NArg() == 1 && flag.Arg(0) == "-"
+	if flag.NArg() > 0 && flag.Arg(0) == ExportCommand {
+		options.Command = ExportCommand
+		options.Export = parseExportOptions(flag.Args()[1:])
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == RenderCommand {
+		options.Command = RenderCommand
+		options.Render = parseRenderOptions(flag.Args()[1:])
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == ListCommand {
+		options.Command = ListCommand
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == StopCommand {
+		options.Command = StopCommand
+		options.Stop = parseStopOptions(flag.Args()[1:])
+	}
+
 	return options
 }
+
+func parseExportOptions(args []string) ExportOptions {
+	export := ExportOptions{}
+	flags := flag.NewFlagSet(ExportCommand, flag.ExitOnError)
+	flags.BoolVar(&export.PDF, "pdf", false, "Export as a PDF")
+	flags.StringVar(&export.Output, "o", "", "Output file, or - for stdout (default: the markdown with a .pdf extension)")
+	flags.StringVar(&export.PageSize, "page", "a4", "Page size: \"a4\" or \"letter\"")
+	flags.Usage = func() {
+		sys.Eprintf("Usage: spamd [options...] export --pdf [-o output.pdf] [-page a4] <path-to-markdown>...\nOptions:\n")
+		flags.PrintDefaults()
+		sys.Eprintf("\nPage breaks are inserted at \\newpage (or \\pagebreak) paragraphs, <!-- pagebreak -->\nand HTML with a page-break-before/after: always style.\n")
+	}
+	flags.Parse(args)
+
+	export.Files = flags.Args()
+	return export
+}
+
+func parseRenderOptions(args []string) RenderOptions {
+	render := RenderOptions{}
+	flags := flag.NewFlagSet(RenderCommand, flag.ExitOnError)
+	flags.BoolVar(&render.Full, "full", false, "Print a full HTML page (with the styles and theme embedded) instead of a fragment")
+	flags.Usage = func() {
+		sys.Eprintf("Usage: spamd [options...] render [-full] <path-to-markdown | ->\nOptions:\n")
+		flags.PrintDefaults()
+		sys.Eprintf("\nThe HTML is printed to stdout. Use - to render markdown read from stdin.\nExits with 1 if the file cannot be read or rendered.\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	render.File = flags.Arg(0)
+	return render
+}
+
+func parseStopOptions(args []string) StopOptions {
+	stop := StopOptions{}
+	flags := flag.NewFlagSet(StopCommand, flag.ExitOnError)
+	flags.BoolVar(&stop.All, "all", false, "Stop every running server, not only the one in the current directory")
+	flags.Usage = func() {
+		sys.Eprintf("Usage: spamd stop [-all]\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	return stop
+}
@@ -0,0 +1,108 @@
+package pdf
+
+import "unicode/utf8"
+
+type Font int
+
+// The standard fonts, which every PDF reader has, so that
+// none need to be embedded.
+const (
+	Helvetica Font = iota
+	HelveticaBold
+	HelveticaOblique
+	HelveticaBoldOblique
+	Courier
+	CourierBold
+)
+
+var fontNames = [...]string{
+	"Helvetica",
+	"Helvetica-Bold",
+	"Helvetica-Oblique",
+	"Helvetica-BoldOblique",
+	"Courier",
+	"Courier-Bold",
+}
+
+// Widths (in 1/1000 of the font size) of the printable ASCII
+// characters, from ' ' to '~', taken from the fonts' AFM files.
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// Characters of WinAnsiEncoding outside of ASCII and Latin-1.
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// Widths of the characters of winAnsiExtras that differ much
+// from that of a letter.
+var extraWidths = map[byte]int{
+	0x82: 222, 0x84: 333, 0x85: 1000, 0x89: 1000, 0x8b: 333, 0x91: 222,
+	0x92: 222, 0x93: 333, 0x94: 333, 0x95: 350, 0x97: 1000, 0x99: 1000, 0x9b: 333,
+}
+
+// Encodes text in WinAnsiEncoding (the encoding of the fonts).
+// Characters that cannot be encoded are replaced with '?'.
+func encode(text string) []byte {
+	encoded := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			encoded = append(encoded, byte(r))
+		case winAnsiExtras[r] != 0:
+			encoded = append(encoded, winAnsiExtras[r])
+		default:
+			encoded = append(encoded, '?')
+		}
+	}
+
+	return encoded
+}
+
+// Returns the width (in points) of text set in font at size.
+//
+// The widths of characters other than ASCII are approximated.
+func TextWidth(font Font, size float64, text string) float64 {
+	if font == Courier || font == CourierBold {
+		return float64(utf8.RuneCountInString(text)) * 600 * size / 1000
+	}
+
+	widths := &helveticaWidths
+	if font == HelveticaBold || font == HelveticaBoldOblique {
+		widths = &helveticaBoldWidths
+	}
+
+	total := 0
+	for _, c := range encode(text) {
+		switch {
+		case c >= ' ' && c <= '~':
+			total += widths[c-' ']
+		case extraWidths[c] != 0:
+			total += extraWidths[c]
+		default:
+			total += 556
+		}
+	}
+
+	return float64(total) * size / 1000
+}
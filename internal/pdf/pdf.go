@@ -0,0 +1,267 @@
+// Package pdf writes PDF documents made of text (in the standard
+// fonts), rectangles, lines, images and links.
+//
+// Coordinates are in points (1/72 inch) from the top-left corner
+// of the page.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+)
+
+type Size struct {
+	Width, Height float64
+}
+
+var (
+	A4     = Size{595.28, 841.89}
+	Letter = Size{612, 792}
+)
+
+type Color struct {
+	R, G, B uint8
+}
+
+func (c Color) String() string {
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+}
+
+type Document struct {
+	size   Size
+	title  string
+	pages  []*Page
+	images []*Image
+}
+
+type Page struct {
+	size    Size
+	content bytes.Buffer
+	links   []link
+}
+
+type link struct {
+	x, y, width, height float64
+	uri                 string
+}
+
+type Image struct {
+	// In pixels.
+	Width, Height int
+
+	name       string
+	colorSpace string
+	filter     string
+	data       []byte
+}
+
+func New(size Size) *Document {
+	return &Document{size: size}
+}
+
+func (d *Document) Size() Size {
+	return d.size
+}
+
+func (d *Document) SetTitle(title string) {
+	d.title = title
+}
+
+func (d *Document) AddPage() *Page {
+	page := &Page{size: d.size}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+func (d *Document) Pages() []*Page {
+	return d.pages
+}
+
+// Adds an image (JPEG, PNG or GIF) to the document, to be drawn
+// on any of its pages. Transparent parts are drawn over white.
+func (d *Document) AddImage(data []byte) (*Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{
+		Width:  config.Width,
+		Height: config.Height,
+		name:   fmt.Sprintf("Im%d", len(d.images)+1),
+	}
+
+	// JPEGs are embedded as is, unless in CMYK (which is stored
+	// inverted by most encoders).
+	switch {
+	case format == "jpeg" && config.ColorModel == color.YCbCrModel:
+		img.colorSpace, img.filter, img.data = "DeviceRGB", "DCTDecode", data
+	case format == "jpeg" && config.ColorModel == color.GrayModel:
+		img.colorSpace, img.filter, img.data = "DeviceGray", "DCTDecode", data
+	default:
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		bounds := decoded.Bounds()
+		rgba := image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, image.White, image.Point{}, draw.Src)
+		draw.Draw(rgba, bounds, decoded, bounds.Min, draw.Over)
+
+		var rgb bytes.Buffer
+		for i := 0; i < len(rgba.Pix); i += 4 {
+			rgb.Write(rgba.Pix[i : i+3])
+		}
+		img.colorSpace, img.filter, img.data = "DeviceRGB", "FlateDecode", compress(rgb.Bytes())
+	}
+
+	d.images = append(d.images, img)
+	return img, nil
+}
+
+// Writes text with its baseline at y.
+func (p *Page) Text(x, y float64, font Font, size float64, color Color, text string) {
+	fmt.Fprintf(&p.content, "BT /F%d %.2f Tf %s rg %.2f %.2f Td %s Tj ET\n",
+		int(font)+1, size, color, x, p.size.Height-y, literal(text))
+}
+
+// Fills a rectangle whose top-left corner is at (x, y).
+func (p *Page) Rect(x, y, width, height float64, fill Color) {
+	fmt.Fprintf(&p.content, "%s rg %.2f %.2f %.2f %.2f re f\n", fill, x, p.size.Height-y-height, width, height)
+}
+
+func (p *Page) Line(x1, y1, x2, y2, width float64, color Color) {
+	fmt.Fprintf(&p.content, "%s RG %.2f w %.2f %.2f m %.2f %.2f l S\n",
+		color, width, x1, p.size.Height-y1, x2, p.size.Height-y2)
+}
+
+// Draws img scaled into the rectangle whose top-left corner is at (x, y).
+func (p *Page) Image(img *Image, x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n",
+		width, height, x, p.size.Height-y-height, img.name)
+}
+
+// Makes the rectangle whose top-left corner is at (x, y) a link to uri.
+func (p *Page) Link(x, y, width, height float64, uri string) {
+	p.links = append(p.links, link{x, p.size.Height - y - height, width, height, uri})
+}
+
+// Writes the document in PDF format.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var out pdfWriter
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 to 3 are the catalog, the page tree and the document
+	// info, followed by the fonts, the images and each page (with its
+	// content and links).
+	firstFont := 4
+	firstImage := firstFont + len(fontNames)
+	firstPage := firstImage + len(d.images)
+
+	out.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+
+	var kids []string
+	n := firstPage
+	for _, page := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", n))
+		n += 2 + len(page.links)
+	}
+	out.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	out.object(3, fmt.Sprintf("<< /Title %s /Producer (spamd) >>", literal(d.title)))
+
+	var fonts, images strings.Builder
+	for i, name := range fontNames {
+		out.object(firstFont+i, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fmt.Fprintf(&fonts, "/F%d %d 0 R ", i+1, firstFont+i)
+	}
+	for i, img := range d.images {
+		out.stream(firstImage+i, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s",
+			img.Width, img.Height, img.colorSpace, img.filter), img.data)
+		fmt.Fprintf(&images, "/%s %d 0 R ", img.name, firstImage+i)
+	}
+	resources := fmt.Sprintf("<< /Font << %s>> /XObject << %s>> >>", fonts.String(), images.String())
+
+	n = firstPage
+	for _, page := range d.pages {
+		var annots []string
+		for i := range page.links {
+			annots = append(annots, fmt.Sprintf("%d 0 R", n+2+i))
+		}
+
+		out.object(n, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R /Annots [%s] >>",
+			page.size.Width, page.size.Height, resources, n+1, strings.Join(annots, " ")))
+		out.stream(n+1, "/Filter /FlateDecode", compress(page.content.Bytes()))
+		for i, l := range page.links {
+			out.object(n+2+i, fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%.2f %.2f %.2f %.2f] /Border [0 0 0] /A << /S /URI /URI %s >> >>",
+				l.x, l.y, l.x+l.width, l.y+l.height, literal(l.uri)))
+		}
+		n += 2 + len(page.links)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", n)
+	for i := 1; i < n; i++ {
+		fmt.Fprintf(&out, "%010d 00000 n \n", out.offsets[i])
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", n, xref)
+
+	return out.WriteTo(w)
+}
+
+// Keeps the offset of each object, for the cross-reference table.
+type pdfWriter struct {
+	bytes.Buffer
+	offsets map[int]int
+}
+
+func (w *pdfWriter) object(n int, body string) {
+	w.begin(n)
+	fmt.Fprintf(w, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+func (w *pdfWriter) stream(n int, dict string, data []byte) {
+	w.begin(n)
+	fmt.Fprintf(w, "%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+	w.Write(data)
+	w.WriteString("\nendstream\nendobj\n")
+}
+
+func (w *pdfWriter) begin(n int) {
+	if w.offsets == nil {
+		w.offsets = make(map[int]int)
+	}
+	w.offsets[n] = w.Len()
+}
+
+// Returns text as a PDF string literal.
+func literal(text string) string {
+	var s strings.Builder
+	s.WriteByte('(')
+	for _, c := range encode(text) {
+		if c == '(' || c == ')' || c == '\\' {
+			s.WriteByte('\\')
+		}
+		s.WriteByte(c)
+	}
+	s.WriteByte(')')
+	return s.String()
+}
+
+func compress(data []byte) []byte {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+	return compressed.Bytes()
+}
@@ -0,0 +1,99 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTextWidth(t *testing.T) {
+	cases := []struct {
+		font     Font
+		text     string
+		expected float64
+	}{
+		{Helvetica, "Hello", 27.336},
+		{HelveticaBold, "Hello", 29.34},
+		{Courier, "Hello", 36},
+		{Helvetica, "", 0},
+	}
+
+	for _, c := range cases {
+		if got := TextWidth(c.font, 12, c.text); math.Abs(got-c.expected) > 0.001 {
+			t.Errorf("TextWidth(%d, 12, %q): got %f; want %f", c.font, c.text, got, c.expected)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	got := encode("a(é)—€漢")
+	want := []byte{'a', '(', 0xe9, ')', 0x97, 0x80, '?'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestWriteDocument(t *testing.T) {
+	doc := New(A4)
+	doc.SetTitle("Spec (draft)")
+
+	var pngData bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	png.Encode(&pngData, img)
+	embedded, err := doc.AddImage(pngData.Bytes())
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	page := doc.AddPage()
+	page.Text(56, 80, HelveticaBold, 20, Color{0x24, 0x29, 0x2f}, "Title (1)")
+	page.Rect(56, 100, 100, 20, Color{0xf6, 0xf8, 0xfa})
+	page.Image(embedded, 56, 130, 50, 50)
+	page.Link(56, 60, 100, 20, "https://example.com")
+	doc.AddPage().Line(56, 56, 200, 56, 1, Color{})
+
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	got := out.String()
+
+	for _, want := range []string{
+		"%PDF-1.4",
+		"/Title (Spec \\(draft\\))",
+		"/Type /Pages /Kids [11 0 R 14 0 R] /Count 2",
+		"/BaseFont /Helvetica-Bold",
+		"/Subtype /Image /Width 2 /Height 2",
+		"/URI (https://example.com)",
+		"%%EOF",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+
+	// Every entry of the cross-reference table points at its object.
+	match := regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(got)
+	xref, _ := strconv.Atoi(match[1])
+	entries := strings.Split(got[xref:], "\n")[3:]
+	for n := 1; n < 16; n++ {
+		offset, _ := strconv.Atoi(entries[n-1][:10])
+		if want := fmt.Sprintf("%d 0 obj", n); !strings.HasPrefix(got[offset:], want) {
+			t.Errorf("object %d: got %q at %d; want %s", n, got[offset:offset+10], offset, want)
+		}
+	}
+}
+
+func TestAddInvalidImage(t *testing.T) {
+	if _, err := New(A4).AddImage([]byte("<svg></svg>")); err == nil {
+		t.Error("got <nil>; want error on unsupported image")
+	}
+}
@@ -45,6 +45,12 @@ func Exists(path string) bool {
 	return true
 }
 
+// Returns true if path is a (non-directory) file.
+func IsFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
 // Returns false if path entered is not a
 // valid markdown file.
 func IsFileWithExt(filepath string, targetExt string) bool {
@@ -0,0 +1,576 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+var (
+	adocHeadingRegex     = regexp.MustCompile(`^(={1,6})\s+(.+?)\s*=*$`)
+	adocAttrEntryRegex   = regexp.MustCompile(`^:([\w-]+):\s*(.*)$`)
+	adocAttrRefRegex     = regexp.MustCompile(`\{([\w-]+)\}`)
+	adocBlockAttrsRegex  = regexp.MustCompile(`^\[([^\[\]].*)\]$`)
+	adocAnchorRegex      = regexp.MustCompile(`^\[\[([\w-]+)(?:,.*)?\]\]$`)
+	adocBlockTitleRegex  = regexp.MustCompile(`^\.([^.\s].*)$`)
+	adocDelimiterRegex   = regexp.MustCompile(`^(-{4,}|\.{4,}|_{4,}|={4,}|\*{4,}|\+{4,}|/{4,}|--|\|={3,})$`)
+	adocBlockImageRegex  = regexp.MustCompile(`^image::([^\s\[]+)\[(.*)\]$`)
+	adocListItemRegex    = regexp.MustCompile(`^\s*(\*{1,5}|-|\.{1,5})\s+(.*)$`)
+	adocAdmonitionRegex  = regexp.MustCompile(`^(NOTE|TIP|IMPORTANT|WARNING|CAUTION):\s+(.*)$`)
+	adocHeadingIdRegex   = regexp.MustCompile(`[^a-z0-9]+`)
+	adocMonospaceRegex   = regexp.MustCompile("`([^`]+)`")
+	adocInlineImageRegex = regexp.MustCompile(`image:([^\s\[]+)\[([^\]]*)\]`)
+	adocLinkRegex        = regexp.MustCompile(`(?:link:([^\s\[]+)|((?:https?|ftp)://[^\s\[]+|mailto:[^\s\[]+))\[([^\]]*)\]`)
+	adocURLRegex         = regexp.MustCompile(`(?:https?|ftp)://[^\s\[<]+`)
+	adocXrefRegex        = regexp.MustCompile(`&lt;&lt;([\w-]+)(?:,\s*(.+?))?&gt;&gt;`)
+	adocStrongRegex      = regexp.MustCompile(`(^|[^\w*])\*(\S|\S.*?\S)\*($|[^\w*])`)
+	adocEmphasisRegex    = regexp.MustCompile(`(^|[^\w_])_(\S|\S.*?\S)_($|[^\w_])`)
+	adocFragmentRegex    = regexp.MustCompile("\x00([0-9]+)\x00")
+)
+
+// Converts AsciiDoc source into HTML.
+//
+// Only the commonly used subset of AsciiDoc is supported: section
+// titles, paragraphs, (nested) lists, listing/literal/quote/example/
+// sidebar/passthrough blocks, tables, images, admonitions, block
+// titles, anchors, attribute entries and references, and the basic
+// inline formatting (bold, italic, monospace, links and xrefs).
+func convertAsciiDoc(key string, source []byte) ([]byte, error) {
+	return convertCached(key, source, func(source []byte) ([]byte, error) {
+		text := strings.ReplaceAll(string(source), "\r\n", "\n")
+		text = strings.ReplaceAll(text, "\x00", "")
+
+		var content bytes.Buffer
+		p := asciiDocParser{out: &content, attrs: make(map[string]string)}
+		p.parseBlocks(strings.Split(text, "\n"), true)
+		return content.Bytes(), nil
+	})
+}
+
+type asciiDocParser struct {
+	out   *bytes.Buffer
+	attrs map[string]string
+
+	// Applied to the next block: its [attribute list], .Title and id.
+	blockAttrs []string
+	blockTitle string
+	blockId    string
+}
+
+type asciiDocListItem struct {
+	ordered bool
+	depth   int
+	text    string
+}
+
+// Writes the blocks in lines. The document header (title, author and
+// revision lines) is only read at the start of the document.
+func (p *asciiDocParser) parseBlocks(lines []string, document bool) {
+	for i := 0; i < len(lines); {
+		line := strings.TrimRight(lines[i], " \t")
+
+		switch {
+		case line == "":
+			i++
+
+		case adocDelimiterRegex.MatchString(line):
+			end := i + 1
+			for end < len(lines) && strings.TrimRight(lines[end], " \t") != line {
+				end++
+			}
+			p.writeDelimitedBlock(line, lines[i+1:min(end, len(lines))])
+			i = end + 1
+
+		case strings.HasPrefix(line, "//"):
+			i++
+
+		case adocAttrEntryRegex.MatchString(line):
+			match := adocAttrEntryRegex.FindStringSubmatch(line)
+			p.attrs[match[1]] = match[2]
+			i++
+
+		case adocAnchorRegex.MatchString(line):
+			p.blockId = adocAnchorRegex.FindStringSubmatch(line)[1]
+			i++
+
+		case adocBlockAttrsRegex.MatchString(line):
+			p.blockAttrs = nil
+			for _, attr := range strings.Split(adocBlockAttrsRegex.FindStringSubmatch(line)[1], ",") {
+				attr = strings.Trim(strings.TrimSpace(attr), `"`)
+				if strings.HasPrefix(attr, "#") {
+					p.blockId = attr[1:]
+					continue
+				}
+				p.blockAttrs = append(p.blockAttrs, attr)
+			}
+			i++
+
+		case adocBlockTitleRegex.MatchString(line):
+			p.blockTitle = adocBlockTitleRegex.FindStringSubmatch(line)[1]
+			i++
+
+		case adocHeadingRegex.MatchString(line):
+			match := adocHeadingRegex.FindStringSubmatch(line)
+			p.writeHeading(len(match[1]), match[2])
+			i++
+
+			if document && len(match[1]) == 1 {
+				// Author and revision lines follow the title.
+				for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+					if match := adocAttrEntryRegex.FindStringSubmatch(lines[i]); match != nil {
+						p.attrs[match[1]] = match[2]
+					}
+				}
+			}
+
+		case line == "'''":
+			p.out.WriteString("<hr>\n")
+			i++
+
+		case adocBlockImageRegex.MatchString(line):
+			match := adocBlockImageRegex.FindStringSubmatch(line)
+			p.writeTitle()
+			fmt.Fprintf(p.out, "<p>%s</p>\n", p.image(match[1], match[2]))
+			p.resetBlock()
+			i++
+
+		case adocListItemRegex.MatchString(line):
+			i = p.parseList(lines, i)
+
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"):
+			// A literal paragraph.
+			var literal []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				literal = append(literal, lines[i])
+			}
+			p.writeTitle()
+			fmt.Fprintf(p.out, "<pre>%s</pre>\n", html.EscapeString(dedent(literal)))
+			p.resetBlock()
+
+		default:
+			var paragraph []string
+			for ; i < len(lines); i++ {
+				next := strings.TrimRight(lines[i], " \t")
+				if next == "" || (len(paragraph) > 0 && adocDelimiterRegex.MatchString(next)) {
+					break
+				}
+				paragraph = append(paragraph, next)
+			}
+			p.writeParagraph(paragraph)
+		}
+	}
+}
+
+// Reads the list starting at lines[start] and returns the
+// index of the line following it.
+func (p *asciiDocParser) parseList(lines []string, start int) int {
+	var items []asciiDocListItem
+
+	i := start
+	for i < len(lines) {
+		line := strings.TrimRight(lines[i], " \t")
+		if match := adocListItemRegex.FindStringSubmatch(line); match != nil {
+			marker := match[1]
+			items = append(items, asciiDocListItem{
+				ordered: marker[0] == '.',
+				depth:   max(len(marker), 1),
+				text:    match[2],
+			})
+			i++
+			continue
+		}
+
+		if line == "" {
+			// Items may be separated by blank lines.
+			next := i
+			for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+				next++
+			}
+			if next < len(lines) && adocListItemRegex.MatchString(lines[next]) {
+				i = next
+				continue
+			}
+			break
+		}
+
+		if line == "+" || adocDelimiterRegex.MatchString(line) {
+			break
+		}
+
+		// Continuation of the last item.
+		items[len(items)-1].text += " " + strings.TrimSpace(line)
+		i++
+	}
+
+	p.writeTitle()
+	p.writeList(items)
+	p.resetBlock()
+
+	// Attached blocks ("+") are not supported; they
+	// are shown as blocks following the list.
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "+" {
+		i++
+	}
+	return i
+}
+
+func (p *asciiDocParser) writeList(items []asciiDocListItem) {
+	// Tags of the lists currently open, the innermost last.
+	var open []string
+
+	for n, item := range items {
+		tag := "ul"
+		if item.ordered {
+			tag = "ol"
+		}
+
+		if n > 0 && item.depth <= len(open) {
+			for len(open) > item.depth {
+				fmt.Fprintf(p.out, "</li>\n</%s>\n", open[len(open)-1])
+				open = open[:len(open)-1]
+			}
+			if open[len(open)-1] == tag {
+				p.out.WriteString("</li>\n")
+			} else {
+				// Another kind of list at the same depth.
+				fmt.Fprintf(p.out, "</li>\n</%s>\n", open[len(open)-1])
+				open = open[:len(open)-1]
+			}
+		}
+		for len(open) < item.depth {
+			fmt.Fprintf(p.out, "<%s>\n", tag)
+			open = append(open, tag)
+		}
+		fmt.Fprintf(p.out, "<li>%s", p.inline(item.text))
+	}
+
+	for len(open) > 0 {
+		fmt.Fprintf(p.out, "</li>\n</%s>\n", open[len(open)-1])
+		open = open[:len(open)-1]
+	}
+}
+
+func (p *asciiDocParser) writeHeading(level int, title string) {
+	id := p.blockId
+	if id == "" {
+		id = "_" + strings.Trim(adocHeadingIdRegex.ReplaceAllString(strings.ToLower(title), "_"), "_")
+	}
+
+	fmt.Fprintf(p.out, "<h%d id=\"%s\">%s</h%d>\n", level, html.EscapeString(id), p.inline(title), level)
+	p.resetBlock()
+}
+
+func (p *asciiDocParser) writeParagraph(lines []string) {
+	var text strings.Builder
+	for n, line := range lines {
+		if n > 0 {
+			text.WriteString("\n")
+		}
+		// A trailing " +" is a hard line break.
+		if trimmed, ok := strings.CutSuffix(line, " +"); ok {
+			text.WriteString(p.inline(trimmed) + "<br>")
+		} else {
+			text.WriteString(p.inline(line))
+		}
+	}
+
+	p.writeTitle()
+	if match := adocAdmonitionRegex.FindStringSubmatch(lines[0]); match != nil {
+		label := match[1]
+		fmt.Fprintf(p.out, "<div class=\"admonition %s\"><p><strong>%s</strong> %s</p></div>\n",
+			strings.ToLower(label), label, strings.TrimPrefix(text.String(), label+": "))
+	} else {
+		fmt.Fprintf(p.out, "<p%s>%s</p>\n", p.idAttr(), text.String())
+	}
+	p.resetBlock()
+}
+
+// Writes a block enclosed by delimiter lines, e.g. "----".
+func (p *asciiDocParser) writeDelimitedBlock(delimiter string, lines []string) {
+	style := ""
+	if len(p.blockAttrs) > 0 {
+		style = p.blockAttrs[0]
+	}
+
+	// Comments are not written at all.
+	if delimiter[0] == '/' {
+		p.resetBlock()
+		return
+	}
+
+	p.writeTitle()
+	switch delimiter[0] {
+	case '-':
+		if delimiter == "--" {
+			p.writeNested("div", "open", lines)
+			return
+		}
+
+		language := ""
+		if style == "source" && len(p.blockAttrs) > 1 {
+			language = p.blockAttrs[1]
+		}
+		p.out.WriteString(highlightSource(language, strings.Join(lines, "\n")))
+		p.out.WriteString("\n")
+
+	case '.':
+		fmt.Fprintf(p.out, "<pre>%s</pre>\n", html.EscapeString(strings.Join(lines, "\n")))
+
+	case '_':
+		// [quote, attribution]
+		attribution := ""
+		if style == "quote" && len(p.blockAttrs) > 1 {
+			attribution = p.blockAttrs[1]
+		}
+		p.writeNested("blockquote", "", lines)
+		if attribution != "" {
+			fmt.Fprintf(p.out, "<p class=\"attribution\">&mdash; %s</p>\n", p.inline(attribution))
+		}
+		return
+
+	case '=':
+		if adocAdmonitionRegex.MatchString(style + ": ") {
+			fmt.Fprintf(p.out, "<div class=\"admonition %s\"><p><strong>%s</strong></p>\n", strings.ToLower(style), style)
+			p.resetBlock()
+			p.parseBlocks(lines, false)
+			p.out.WriteString("</div>\n")
+			return
+		}
+		p.writeNested("div", "example", lines)
+		return
+
+	case '*':
+		p.writeNested("div", "sidebar", lines)
+		return
+
+	case '+':
+		// Passed through as raw HTML, if allowed like in markdowns
+		// (and sanitized in safe mode, see convertCached).
+		if !serviceConfig.ExtensionEnabled("unsafe") {
+			p.out.WriteString("<!-- raw HTML omitted -->\n")
+			break
+		}
+		p.out.WriteString(strings.Join(lines, "\n"))
+		p.out.WriteString("\n")
+
+	case '|':
+		p.writeTable(lines)
+	}
+
+	p.resetBlock()
+}
+
+// Writes lines as blocks inside a tag (with class, if not empty).
+func (p *asciiDocParser) writeNested(tag string, class string, lines []string) {
+	if class != "" {
+		fmt.Fprintf(p.out, "<%s class=\"%s\"%s>\n", tag, class, p.idAttr())
+	} else {
+		fmt.Fprintf(p.out, "<%s%s>\n", tag, p.idAttr())
+	}
+
+	p.resetBlock()
+	p.parseBlocks(lines, false)
+	fmt.Fprintf(p.out, "</%s>\n", tag)
+}
+
+// Writes the rows of a table. The number of columns is given by the
+// cells on the first line, which is a header if followed by a blank
+// line (or if the table has the header option).
+func (p *asciiDocParser) writeTable(lines []string) {
+	var cells []string
+	columns := 0
+	header := false
+	for _, attr := range p.blockAttrs {
+		header = header || attr == "%header" || attr == "options=header"
+	}
+
+	for n, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		lineCells := strings.Split(line, "|")[1:]
+		if columns == 0 {
+			columns = len(lineCells)
+			header = header || (n+1 < len(lines) && strings.TrimSpace(lines[n+1]) == "")
+		}
+		for _, cell := range lineCells {
+			cells = append(cells, strings.TrimSpace(cell))
+		}
+	}
+	if columns == 0 {
+		return
+	}
+
+	fmt.Fprintf(p.out, "<table%s>\n", p.idAttr())
+	for row := 0; row*columns < len(cells); row++ {
+		tag := "td"
+		if row == 0 && header {
+			tag = "th"
+			p.out.WriteString("<thead>\n")
+		} else if row == 0 || (row == 1 && header) {
+			p.out.WriteString("<tbody>\n")
+		}
+
+		p.out.WriteString("<tr>")
+		for column := 0; column < columns; column++ {
+			cell := ""
+			if n := row*columns + column; n < len(cells) {
+				cell = p.inline(cells[n])
+			}
+			fmt.Fprintf(p.out, "<%s>%s</%s>", tag, cell, tag)
+		}
+		p.out.WriteString("</tr>\n")
+
+		if row == 0 && header {
+			p.out.WriteString("</thead>\n")
+			if columns == len(cells) {
+				// No body.
+				p.out.WriteString("</table>\n")
+				return
+			}
+		}
+	}
+	p.out.WriteString("</tbody>\n</table>\n")
+}
+
+func (p *asciiDocParser) writeTitle() {
+	if p.blockTitle != "" {
+		fmt.Fprintf(p.out, "<div class=\"title\">%s</div>\n", p.inline(p.blockTitle))
+	}
+}
+
+func (p *asciiDocParser) idAttr() string {
+	if p.blockId == "" {
+		return ""
+	}
+	return fmt.Sprintf(" id=\"%s\"", html.EscapeString(p.blockId))
+}
+
+func (p *asciiDocParser) resetBlock() {
+	p.blockAttrs = nil
+	p.blockTitle = ""
+	p.blockId = ""
+}
+
+// Returns an <img> for target with the alt text from its attributes,
+// or just the alt text if target is not an allowed URL.
+func (p *asciiDocParser) image(target string, attrs string) string {
+	alt := strings.TrimSpace(strings.Split(attrs, ",")[0])
+	if !isAllowedURL("src", target) {
+		return html.EscapeString(alt)
+	}
+	return fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(target), html.EscapeString(alt))
+}
+
+// Applies inline formatting to text (escaped along the way).
+func (p *asciiDocParser) inline(text string) string {
+	text = adocAttrRefRegex.ReplaceAllStringFunc(text, func(ref string) string {
+		if value, ok := p.attrs[ref[1:len(ref)-1]]; ok {
+			return value
+		}
+		return ref
+	})
+	text = html.EscapeString(text)
+
+	// Generated HTML is set aside while the rest of the text is
+	// formatted, so that e.g. underscores in URLs are kept as is.
+	var fragments []string
+	protect := func(fragment string) string {
+		fragments = append(fragments, fragment)
+		return "\x00" + strconv.Itoa(len(fragments)-1) + "\x00"
+	}
+
+	text = adocMonospaceRegex.ReplaceAllStringFunc(text, func(m string) string {
+		return protect("<code>" + m[1:len(m)-1] + "</code>")
+	})
+	text = adocInlineImageRegex.ReplaceAllStringFunc(text, func(m string) string {
+		match := adocInlineImageRegex.FindStringSubmatch(m)
+		return protect(p.image(html.UnescapeString(match[1]), html.UnescapeString(match[2])))
+	})
+	text = adocLinkRegex.ReplaceAllStringFunc(text, func(m string) string {
+		match := adocLinkRegex.FindStringSubmatch(m)
+		target, label := match[1]+match[2], match[3]
+		if label == "" {
+			label = target
+		}
+		if !isAllowedURL("href", html.UnescapeString(target)) {
+			return protect(label)
+		}
+		return protect(fmt.Sprintf(`<a href="%s">%s</a>`, target, label))
+	})
+	text = adocURLRegex.ReplaceAllStringFunc(text, func(m string) string {
+		url := strings.TrimRight(m, ".,;:!?)")
+		return protect(fmt.Sprintf(`<a href="%s">%s</a>`, url, url)) + m[len(url):]
+	})
+	text = adocXrefRegex.ReplaceAllStringFunc(text, func(m string) string {
+		match := adocXrefRegex.FindStringSubmatch(m)
+		label := match[2]
+		if label == "" {
+			label = "[" + match[1] + "]"
+		}
+		return protect(fmt.Sprintf(`<a href="#%s">%s</a>`, match[1], label))
+	})
+	text = adocStrongRegex.ReplaceAllString(text, "$1<strong>$2</strong>$3")
+	text = adocEmphasisRegex.ReplaceAllString(text, "$1<em>$2</em>$3")
+
+	// Fragments may contain earlier ones (e.g. monospace text in the
+	// label of a link), which are expanded as well.
+	var expand func(text string) string
+	expand = func(text string) string {
+		return adocFragmentRegex.ReplaceAllStringFunc(text, func(m string) string {
+			n, _ := strconv.Atoi(m[1 : len(m)-1])
+			if n >= len(fragments) {
+				return ""
+			}
+			return expand(fragments[n])
+		})
+	}
+	return expand(text)
+}
+
+// Removes the indentation common to all lines.
+func dedent(lines []string) string {
+	indent := -1
+	for _, line := range lines {
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent == -1 || n < indent {
+			indent = n
+		}
+	}
+
+	for i := range lines {
+		lines[i] = lines[i][indent:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns code highlighted with the configured code block style, or
+// just escaped if the language is not known.
+func highlightSource(language string, code string) string {
+	lexer := lexers.Get(language)
+	if language == "" || lexer == nil {
+		return fmt.Sprintf("<pre><code>%s</code></pre>", html.EscapeString(code))
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return fmt.Sprintf("<pre><code>%s</code></pre>", html.EscapeString(code))
+	}
+
+	// Inline styles are stripped by sanitize(), so classes are
+	// used in safe mode like for markdown code blocks.
+	var highlighted bytes.Buffer
+	formatter := chromahtml.New(chromahtml.WithClasses(serviceConfig.Safe))
+	formatter.Format(&highlighted, styles.Get(serviceConfig.CodeBlockTheme), iterator)
+	return highlighted.String()
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConvertAsciiDoc(t *testing.T) {
+	source := `= Title
+Jane Doe
+:version: 1.2
+
+Some *bold*, _italic_ and ` + "`a_b`" + ` text, version {version}.
+See https://example.com/a_b and link:docs/x.adoc[the docs].
+
+[[setup]]
+== Set up
+
+* one
+** nested
+. first
+
+NOTE: Read this.
+
+|===
+|A |B
+
+|1 |2
+|===
+`
+
+	got, err := convertAsciiDoc("test.adoc", []byte(source))
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	for _, want := range []string{
+		`<h1 id="_title">Title</h1>`,
+		`<strong>bold</strong>`,
+		`<em>italic</em>`,
+		`<code>a_b</code>`,
+		`version 1.2.`,
+		`<a href="https://example.com/a_b">https://example.com/a_b</a>`,
+		`<a href="docs/x.adoc">the docs</a>`,
+		`<h2 id="setup">Set up</h2>`,
+		"<li>one<ul>\n<li>nested</li>\n</ul>\n</li>\n</ul>\n<ol>\n<li>first</li>",
+		`<div class="admonition note"><p><strong>NOTE</strong> Read this.</p></div>`,
+		"<thead>\n<tr><th>A</th><th>B</th></tr>",
+		"<tr><td>1</td><td>2</td></tr>",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+	if strings.Contains(string(got), "Jane Doe") {
+		t.Errorf("got %s; want author line omitted", got)
+	}
+}
+
+func TestAsciiDocEscapesText(t *testing.T) {
+	got, _ := convertAsciiDoc("test.adoc", []byte("<script>alert(1)</script> link:javascript:alert(1)[click]\n"))
+
+	if strings.Contains(string(got), "<script>") || strings.Contains(string(got), "javascript:") {
+		t.Errorf("got %s; want text escaped and unsafe link dropped", got)
+	}
+}
+
+func TestAsciiDocDelimitedBlocks(t *testing.T) {
+	source := "[source,go]\n----\nfunc main() {}\n----\n\n....\n<literal>\n....\n\n____\nQuoted\n____\n\n////\nhidden\n////\n"
+
+	got, _ := convertAsciiDoc("test.adoc", []byte(source))
+
+	for _, want := range []string{
+		`>func</span>`,
+		"<pre>&lt;literal&gt;</pre>",
+		"<blockquote>\n<p>Quoted</p>\n</blockquote>",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+	if strings.Contains(string(got), "hidden") {
+		t.Errorf("got %s; want comment block omitted", got)
+	}
+}
+
+func TestAsciiDocPassthroughHonorsUnsafe(t *testing.T) {
+	confMu.Lock()
+	savedExtensions := serviceConfig.Extensions
+	defer func() {
+		serviceConfig.Extensions = savedExtensions
+		confMu.Unlock()
+	}()
+	source := "++++\n<video src=\"demo.mp4\"></video>\n++++\n"
+
+	serviceConfig.Extensions = map[string]bool{"unsafe": true}
+	got, _ := convertAsciiDoc("test.adoc", []byte(source))
+	if !strings.Contains(string(got), `<video src="demo.mp4"></video>`) {
+		t.Errorf("got %s; want the raw HTML passed through", got)
+	}
+
+	serviceConfig.Extensions = map[string]bool{"unsafe": false}
+	got, _ = convertAsciiDoc("test.adoc", []byte(source))
+	if strings.Contains(string(got), "<video") || !strings.Contains(string(got), "<!-- raw HTML omitted -->") {
+		t.Errorf("got %s; want the raw HTML omitted", got)
+	}
+}
+
+func TestAsciiDocExpandsNestedInline(t *testing.T) {
+	got, _ := convertAsciiDoc("test.adoc", []byte("See link:https://example.com[the `config` docs] and https://example.com/`x`.\n"))
+
+	if strings.Contains(string(got), "\x00") {
+		t.Errorf("got %q; want no placeholders left", got)
+	}
+	if !strings.Contains(string(got), `<a href="https://example.com">the <code>config</code> docs</a>`) {
+		t.Errorf("got %s; want monospace text inside the link", got)
+	}
+}
+
+func TestRenderFileByFormat(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.adoc")
+	file.WriteString("== Heading\n")
+	defer os.Remove(file.Name())
+
+	got, err := renderFile(file.Name())
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if want := `<h2 id="_heading">Heading</h2>`; !strings.Contains(string(got), want) {
+		t.Errorf("got %s; want to contain %s", got, want)
+	}
+
+	if !isMarkup("docs/guide.ADOC") || isMarkup("main.go") {
+		t.Error("isMarkup: want only documents recognized")
+	}
+}
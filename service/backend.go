@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"spamd/service/config"
+)
+
+// A backend renders markdown source into HTML.
+type backend interface {
+	Render(source []byte, content *bytes.Buffer) error
+}
+
+// Renders locally with goldmark (the default).
+type localBackend struct{}
+
+func (localBackend) Render(source []byte, content *bytes.Buffer) error {
+	return markdown.Get().Convert(source, content)
+}
+
+// Renders with a Github-compatible /markdown HTTP endpoint for
+// previews identical to Github. Falls back to the local backend
+// if the endpoint fails, which is not cached (see errNotCached).
+type githubBackend struct {
+	url      string
+	client   *http.Client
+	fallback backend
+}
+
+func newGithubBackend(url string) *githubBackend {
+	return &githubBackend{
+		url:      strings.TrimSuffix(url, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		fallback: localBackend{},
+	}
+}
+
+func (g *githubBackend) request(source []byte) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"text": string(source),
+		// Render as a document (not a comment), like a README.
+		"mode": "markdown",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", g.url+"/markdown", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	// Authenticated requests have a much higher rate limit.
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && sendsToken(g.url) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	html, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s/markdown returned %s", g.url, res.Status)
+	}
+
+	return html, nil
+}
+
+func (g *githubBackend) Render(source []byte, content *bytes.Buffer) error {
+	html, err := g.request(source)
+	if err != nil {
+		slog.Warn("Failed to render with the API, rendering locally instead", "url", g.url, "error", err)
+		if err := g.fallback.Render(source, content); err != nil {
+			return err
+		}
+		return errNotCached
+	}
+
+	content.Write(html)
+	return nil
+}
+
+// Returns true if $GITHUB_TOKEN may be sent to the endpoint at
+// rendererURL, i.e. the Github API over https. Other endpoints
+// (e.g. a local or third-party renderer) never receive it.
+func sendsToken(rendererURL string) bool {
+	u, err := url.Parse(rendererURL)
+	if err != nil {
+		return false
+	}
+	api, _ := url.Parse(config.DEFAULT_RENDERER_URL)
+	return u.Scheme == "https" && strings.EqualFold(u.Host, api.Host)
+}
+
+// Holds the backend selected in the config, which is only rebuilt
+// (along with its HTTP client) when the renderer options change.
+type rendererBackend struct {
+	lock    sync.Mutex
+	options string
+	backend backend
+}
+
+var renderBackend = &rendererBackend{}
+
+// Returns the backend for the current renderer options.
+func (r *rendererBackend) Get() backend {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	options := serviceConfig.Renderer + " " + serviceConfig.RendererURL
+	if r.backend == nil || r.options != options {
+		r.backend = localBackend{}
+		if serviceConfig.Renderer == config.GITHUB_RENDERER {
+			r.backend = newGithubBackend(serviceConfig.RendererURL)
+		}
+		r.options = options
+	}
+
+	return r.backend
+}
+
+// Returns the backend selected in the config.
+func activeBackend() backend {
+	return renderBackend.Get()
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGithubBackendRender(t *testing.T) {
+	var gotText, gotMode string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/markdown" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		gotText, gotMode = body["text"], body["mode"]
+		w.Write([]byte(`<h1><a id="user-content-header" class="anchor" href="#header"></a>Header</h1>`))
+	}))
+	defer s.Close()
+
+	var content bytes.Buffer
+	if err := newGithubBackend(s.URL+"/").Render([]byte("# Header"), &content); err != nil {
+		t.Errorf("Should not return error. Got error \"%s\"", err)
+	}
+
+	if gotText != "# Header" || gotMode != "markdown" {
+		t.Errorf("got text %q mode %q; want text %q mode %q", gotText, gotMode, "# Header", "markdown")
+	}
+	want := `<h1><a id="user-content-header" class="anchor" href="#header"></a>Header</h1>`
+	if content.String() != want {
+		t.Errorf("got %s; want %s", content.String(), want)
+	}
+}
+
+func TestGithubBackendFallbackOnError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer s.Close()
+
+	var content bytes.Buffer
+	if err := newGithubBackend(s.URL).Render([]byte("# Header"), &content); !errors.Is(err, errNotCached) {
+		t.Errorf("got %v; want errNotCached", err)
+	}
+
+	want := `<h1 id="header">Header</h1>
+`
+	if content.String() != want {
+		t.Errorf("got %s; want %s", content.String(), want)
+	}
+}
+
+func TestGithubBackendFallbackNotCached(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("<h1>From the API</h1>"))
+	}))
+	defer s.Close()
+
+	confMu.Lock()
+	savedRenderer, savedURL := serviceConfig.Renderer, serviceConfig.RendererURL
+	defer func() {
+		serviceConfig.Renderer, serviceConfig.RendererURL = savedRenderer, savedURL
+		confMu.Unlock()
+	}()
+	serviceConfig.Renderer, serviceConfig.RendererURL = "github", s.URL
+
+	key := "fallback.md"
+	defer cache.Evict(key)
+	content, err := convertMarkdown(key, []byte("# Header"))
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if want := "<h1 id=\"header\">Header</h1>\n"; string(content) != want {
+		t.Errorf("got %s; want %s", content, want)
+	}
+
+	// Once the API is back, the page is no longer rendered locally.
+	failing.Store(false)
+	content, _ = convertMarkdown(key, []byte("# Header"))
+	if want := "<h1>From the API</h1>"; string(content) != want {
+		t.Errorf("got %s; want %s", content, want)
+	}
+}
+
+func TestActiveBackend(t *testing.T) {
+	confMu.Lock()
+	savedRenderer := serviceConfig.Renderer
+	defer func() {
+		serviceConfig.Renderer = savedRenderer
+		confMu.Unlock()
+	}()
+
+	serviceConfig.Renderer = "local"
+	if _, ok := activeBackend().(localBackend); !ok {
+		t.Errorf("got %T; want localBackend", activeBackend())
+	}
+
+	serviceConfig.Renderer = "github"
+	github, ok := activeBackend().(*githubBackend)
+	if !ok {
+		t.Fatalf("got %T; want *githubBackend", activeBackend())
+	}
+	// Built once for the same options.
+	if got := activeBackend(); got != backend(github) {
+		t.Errorf("got %p; want the same backend %p", got, github)
+	}
+}
+
+func TestSendsTokenOnlyToGithub(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected bool
+	}{
+		{"https://api.github.com", true},
+		{"https://API.github.com/", true},
+		{"http://api.github.com", false},
+		{"https://api.github.com.example.com", false},
+		{"https://example.com/api/v3", false},
+		{"http://127.0.0.1:8080", false},
+	}
+
+	for _, c := range cases {
+		if got := sendsToken(c.url); got != c.expected {
+			t.Errorf("%s: got %t; want %t", c.url, got, c.expected)
+		}
+	}
+}
+
+func TestGithubBackendDoesNotLeakToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "secret")
+	var got string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.Write([]byte("<p>ok</p>"))
+	}))
+	defer s.Close()
+
+	var content bytes.Buffer
+	newGithubBackend(s.URL).Render([]byte("ok"), &content)
+	if got != "" {
+		t.Errorf("got %s; want no Authorization header", got)
+	}
+}
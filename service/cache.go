@@ -0,0 +1,211 @@
+package service
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"spamd/internal/sys"
+)
+
+var (
+	// Shared by all connections, so that multiple tabs previewing the
+	// same file only convert it once per change.
+	cache = newRenderCache()
+
+	// Returned by a conversion along with a result that must not be
+	// cached, e.g. rendered locally after the renderer API failed (so
+	// that the API is tried again on the next render).
+	errNotCached = errors.New("Result must not be cached.")
+)
+
+type cacheEntry struct {
+	hash    [sha256.Size]byte
+	options string
+	content []byte
+}
+
+// Identifies a conversion of the contents (hash) of a file.
+type cacheCall struct {
+	key     string
+	hash    [sha256.Size]byte
+	options string
+}
+
+// Contents of a file as read at its last modified time.
+type cachedSource struct {
+	modtime time.Time
+	data    []byte
+}
+
+// A conversion in progress, whose result is shared with the
+// callers waiting for it (until done is closed).
+type pendingConversion struct {
+	done    chan struct{}
+	content []byte
+	err     error
+}
+
+// Holds the last converted HTML of each file.
+//
+// An entry is only valid for the exact contents (hash) of the file
+// and the render options used to convert it. The watcher also drops
+// the entry of a file once it is modified.
+type renderCache struct {
+	entries map[string]cacheEntry
+	pending map[cacheCall]*pendingConversion
+	lock    sync.Mutex
+
+	sources map[string]cachedSource
+	// Held while reading a file, so that it is read only once
+	// by the tabs refreshed at once.
+	sourcesLock sync.Mutex
+
+	hits   uint64
+	misses uint64
+}
+
+func (p *pendingConversion) result() ([]byte, error) {
+	if errors.Is(p.err, errNotCached) {
+		return p.content, nil
+	}
+	return p.content, p.err
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{
+		entries: make(map[string]cacheEntry),
+		pending: make(map[cacheCall]*pendingConversion),
+		sources: make(map[string]cachedSource),
+	}
+}
+
+// Returns the cached HTML for filepath, if it was converted from
+// the same contents with the same options.
+//
+// Must be called while holding the lock.
+func (c *renderCache) get(filepath string, hash [sha256.Size]byte, options string) ([]byte, bool) {
+	entry, ok := c.entries[filepath]
+	if !ok || entry.hash != hash || entry.options != options {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	return entry.content, true
+}
+
+// Returns the HTML converted from source (the contents of the file
+// cached under key) with convert, unless it is cached already.
+//
+// Tabs refreshed at once (e.g. after a save) share a single conversion:
+// callers for the same contents and options wait for the one in progress,
+// which counts as a hit for them.
+//
+// A result returned by convert with errNotCached is returned (without
+// the error) but not cached.
+func (c *renderCache) Convert(key string, source []byte, convert func(source []byte) ([]byte, error)) ([]byte, error) {
+	call := cacheCall{key, sha256.Sum256(source), renderOptions()}
+
+	c.lock.Lock()
+	if p, ok := c.pending[call]; ok {
+		c.hits++
+		c.lock.Unlock()
+		<-p.done
+		return p.result()
+	}
+	if content, ok := c.get(key, call.hash, call.options); ok {
+		c.lock.Unlock()
+		return content, nil
+	}
+	p := &pendingConversion{done: make(chan struct{})}
+	c.pending[call] = p
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		if p.err == nil {
+			c.entries[key] = cacheEntry{hash: call.hash, options: call.options, content: p.content}
+		}
+		delete(c.pending, call)
+		c.lock.Unlock()
+		close(p.done)
+	}()
+
+	p.content, p.err = convert(source)
+	return p.result()
+}
+
+// Returns the contents of the file at filepath (see readSource).
+//
+// Files in the working tree are only read again once modified, so
+// that the tabs refreshed at once (e.g. after a save) share a single
+// read. Revisions are not cached since a ref (e.g. HEAD) may move.
+func (c *renderCache) ReadSource(filepath string) ([]byte, error) {
+	if isStdinPath(filepath) || isRevisionPath(filepath) {
+		return readSource(filepath)
+	}
+	modtime, err := sys.Modtime(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	c.sourcesLock.Lock()
+	defer c.sourcesLock.Unlock()
+
+	if source, ok := c.sources[filepath]; ok && source.modtime.Equal(modtime) {
+		return source.data, nil
+	}
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	c.sources[filepath] = cachedSource{modtime: modtime, data: data}
+	return data, nil
+}
+
+// Drops the contents and HTML cached for the file at filepath,
+// e.g. once it is modified.
+func (c *renderCache) Invalidate(filepath string) {
+	c.sourcesLock.Lock()
+	delete(c.sources, filepath)
+	c.sourcesLock.Unlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, filepath)
+}
+
+// Drops everything cached for the file at filepath, including the
+// revisions it was diffed against (see renderDiff), once no tab
+// previews it anymore.
+func (c *renderCache) Evict(filepath string) {
+	c.Invalidate(filepath)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key := range c.entries {
+		if strings.HasSuffix(key, ":"+filepath) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Returns the number of hits, misses and the ratio of hits
+// over all lookups (0 if there were no lookups).
+func (c *renderCache) Stats() (uint64, uint64, float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	total := c.hits + c.misses
+	if total == 0 {
+		return c.hits, c.misses, 0
+	}
+
+	return c.hits, c.misses, float64(c.hits) / float64(total)
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenderCacheConvert(t *testing.T) {
+	confMu.Lock()
+	savedTheme := serviceConfig.CodeBlockTheme
+	defer func() {
+		serviceConfig.CodeBlockTheme = savedTheme
+		confMu.Unlock()
+	}()
+
+	c := newRenderCache()
+	calls := 0
+	convert := func(source []byte) ([]byte, error) {
+		calls++
+		return []byte("<h1>Header</h1>"), nil
+	}
+
+	serviceConfig.CodeBlockTheme = "monokai"
+	for i := 0; i < 2; i++ {
+		content, err := c.Convert("a.md", []byte("# Header"), convert)
+		if err != nil {
+			t.Fatalf("Should not return error. Got error \"%s\"", err)
+		}
+		if string(content) != "<h1>Header</h1>" {
+			t.Errorf("got %s; want <h1>Header</h1>", content)
+		}
+	}
+
+	// Different contents or options should miss.
+	c.Convert("a.md", []byte("# Other"), convert)
+	serviceConfig.CodeBlockTheme = "vim"
+	c.Convert("a.md", []byte("# Other"), convert)
+	if calls != 3 {
+		t.Errorf("got %d conversions; want 3", calls)
+	}
+
+	hits, misses, hitRate := c.Stats()
+	if hits != 1 || misses != 3 {
+		t.Errorf("got %d hits, %d misses; want 1 hits, 3 misses", hits, misses)
+	}
+	if hitRate != 0.25 {
+		t.Errorf("got hit rate %f; want 0.25", hitRate)
+	}
+}
+
+func TestRenderCacheInvalidate(t *testing.T) {
+	c := newRenderCache()
+	calls := 0
+	convert := func(source []byte) ([]byte, error) {
+		calls++
+		return []byte("<h1>Header</h1>"), nil
+	}
+
+	c.Convert("a.md", []byte("# Header"), convert)
+	c.Invalidate("a.md")
+	c.Convert("a.md", []byte("# Header"), convert)
+	if calls != 2 {
+		t.Errorf("got %d conversions; want 2 after invalidation", calls)
+	}
+}
+
+func TestRenderCacheEvict(t *testing.T) {
+	c := newRenderCache()
+	convert := func(source []byte) ([]byte, error) {
+		return []byte("<h1>Header</h1>"), nil
+	}
+
+	c.Convert("a.md", []byte("# Header"), convert)
+	c.Convert("HEAD:a.md", []byte("# Header"), convert)
+	c.Convert("HEAD:b.md", []byte("# Header"), convert)
+	c.Evict("a.md")
+
+	if _, ok := c.entries["a.md"]; ok {
+		t.Error("got entry for a.md; want it evicted")
+	}
+	if _, ok := c.entries["HEAD:a.md"]; ok {
+		t.Error("got entry for HEAD:a.md; want it evicted")
+	}
+	if _, ok := c.entries["HEAD:b.md"]; !ok {
+		t.Error("got no entry for HEAD:b.md; want it kept")
+	}
+}
+
+func TestReadSourceOncePerModification(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.md")
+	file.WriteString("# Header")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	c := newRenderCache()
+	first, err := c.ReadSource(file.Name())
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	second, _ := c.ReadSource(file.Name())
+	if string(first) != "# Header" || &first[0] != &second[0] {
+		t.Errorf("got %q and %q; want the same read of # Header", first, second)
+	}
+
+	// Read again once modified.
+	os.WriteFile(file.Name(), []byte("# Other"), 0644)
+	os.Chtimes(file.Name(), time.Time{}, time.Now().Add(time.Second))
+	if got, _ := c.ReadSource(file.Name()); string(got) != "# Other" {
+		t.Errorf("got %q; want # Other", got)
+	}
+}
+
+func TestConvertOnceForSameContents(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*")
+	file.WriteString("# Header")
+
+	calls := 0
+	converterMutex.Lock()
+	savedConverter := converter
+	converter = func(filedata []byte, content *bytes.Buffer) error {
+		calls++
+		return savedConverter(filedata, content)
+	}
+	defer func() {
+		os.Remove(file.Name())
+		converter = savedConverter
+		converterMutex.Unlock()
+	}()
+
+	for i := 0; i < 3; i++ {
+		if _, err := convertMarkdownToHTML(file.Name()); err != nil {
+			t.Errorf("Should not return error. Got error \"%s\"", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d conversions; want 1", calls)
+	}
+
+	// Modified contents are converted again.
+	file.WriteString("\n\nNext paragraph.")
+	convertMarkdownToHTML(file.Name())
+	if calls != 2 {
+		t.Errorf("got %d conversions; want 2", calls)
+	}
+}
+
+func TestConvertSharesConversionInProgress(t *testing.T) {
+	c := newRenderCache()
+	release := make(chan struct{})
+	calls := 0
+	convert := func(source []byte) ([]byte, error) {
+		calls++
+		<-release
+		return []byte("<h1>Header</h1>"), nil
+	}
+
+	const tabs = 4
+	var wg sync.WaitGroup
+	results := make([][]byte, tabs)
+	for i := 0; i < tabs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = c.Convert("a.md", []byte("# Header"), convert)
+		}(i)
+	}
+
+	// Wait for the other tabs to wait for the first conversion.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if hits, _, _ := c.Stats(); hits == tabs-1 {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("got %d conversions; want 1", calls)
+	}
+	for _, result := range results {
+		if string(result) != "<h1>Header</h1>" {
+			t.Errorf("got %s; want <h1>Header</h1>", result)
+		}
+	}
+	if hits, misses, _ := c.Stats(); hits != tabs-1 || misses != 1 {
+		t.Errorf("got %d hits, %d misses; want %d hits, 1 misses", hits, misses, tabs-1)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+const (
+	// Number of leading bytes checked to tell text from binary files.
+	sniff_len = 8000
+)
+
+func isMarkdown(filepath string) bool {
+	return serviceConfig.IsMarkdown(filepath)
+}
+
+// Returns false for binary files, i.e. those with NUL bytes
+// or invalid UTF-8 in their leading bytes.
+func isText(data []byte) bool {
+	if len(data) > sniff_len {
+		data = data[:sniff_len]
+		// Drop a multi-byte character cut off at the end.
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+
+	return bytes.IndexByte(data, 0) == -1 && utf8.Valid(data)
+}
+
+// Highlights a source file (falling back to plain text if its
+// language is unknown) with the configured code block style.
+//
+// Each line is numbered and can be linked to with #L<n> (or a range
+// with #L<from>-L<to>) like on Github.
+func convertCodeToHTML(filepath string) ([]byte, error) {
+	source, err := cache.ReadSource(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", filepath, err)
+	}
+	if !isText(source) {
+		return nil, fmt.Errorf("%s is not a text file.", filepath)
+	}
+
+	return cache.Convert(filepath, source, func(source []byte) ([]byte, error) {
+		return highlightCode(filepath, source)
+	})
+}
+
+// Highlights source, named filepath, into numbered lines.
+func highlightCode(filepath string, source []byte) ([]byte, error) {
+	lexer := lexers.Match(path.Base(filepath))
+	if lexer == nil {
+		lexer = lexers.Analyse(string(source))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, string(source))
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	formatter := chromahtml.New(
+		chromahtml.WithLineNumbers(true),
+		chromahtml.WithLinkableLineNumbers(true, "L"),
+	)
+	content.WriteString(`<div class="code-file">`)
+	if err := formatter.Format(&content, styles.Get(serviceConfig.CodeBlockTheme), iterator); err != nil {
+		return nil, err
+	}
+	content.WriteString(`</div>`)
+
+	return content.Bytes(), nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"spamd/service/config"
+)
+
+func TestIsText(t *testing.T) {
+	cases := []struct {
+		data     []byte
+		expected bool
+	}{
+		{[]byte("package main\n"), true},
+		{[]byte("héllo wörld"), true},
+		{[]byte{}, true},
+		{[]byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00}, false},
+		{[]byte{0xff, 0xfe, 0xfd}, false},
+		// Multi-byte character cut off by the sniffed length.
+		{[]byte(strings.Repeat("a", sniff_len-1) + "é"), true},
+	}
+
+	for _, c := range cases {
+		if got := isText(c.data); got != c.expected {
+			t.Errorf("isText(%q): got %t; want %t", c.data[:min(len(c.data), 16)], got, c.expected)
+		}
+	}
+}
+
+func TestConvertCodeToHTML(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.go")
+	file.WriteString("package main\n\nfunc main() {}\n")
+	defer os.Remove(file.Name())
+
+	got, err := renderFile(file.Name())
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	for _, want := range []string{
+		`<div class="code-file">`,
+		`id="L1"`,
+		`href="#L3"`,
+		`>package</span>`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+}
+
+func TestConvertBinaryFileFails(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.bin")
+	file.Write([]byte{0x00, 0x01, 0x02})
+	defer os.Remove(file.Name())
+
+	if _, err := renderFile(file.Name()); err == nil {
+		t.Error("got <nil>; want error on binary file")
+	}
+}
+
+func TestRedirectTextFiles(t *testing.T) {
+	text, _ := os.CreateTemp(".", "*.yaml")
+	text.WriteString("key: value\n")
+	defer os.Remove(text.Name())
+
+	binary, _ := os.CreateTemp(".", "*.bin")
+	binary.Write([]byte{0x00, 0x01, 0x02})
+	defer os.Remove(binary.Name())
+
+	if uri := "/" + text.Name()[2:]; !redirectIfNotMarkdown(uri) {
+		t.Errorf("redirectIfNotMarkdown(\"%s\") should return true.", uri)
+	}
+	if uri := "/" + binary.Name()[2:]; redirectIfNotMarkdown(uri) {
+		t.Errorf("redirectIfNotMarkdown(\"%s\") should return false.", uri)
+	}
+	if redirectIfNotMarkdown("/frontend") {
+		t.Error("redirectIfNotMarkdown(\"/frontend\") should return false.")
+	}
+}
+
+func TestRedirectRefusesFilesOutsideWorkingDir(t *testing.T) {
+	outside, _ := os.CreateTemp(t.TempDir(), "*.md")
+	outside.WriteString("# Secret\n")
+	outside.Close()
+
+	image, _ := os.CreateTemp("..", "*.png")
+	image.Close()
+	defer os.Remove(image.Name())
+
+	link := "link-to-outside.md"
+	if err := os.Symlink(outside.Name(), link); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	defer os.Remove(link)
+
+	cwd, _ := os.Getwd()
+	rel, _ := filepath.Rel(cwd, outside.Name())
+	for _, uri := range []string{
+		"/" + rel,
+		"/../" + filepath.Base(image.Name()),
+		"/" + link,
+		config.RefreshPrefix + "/" + rel,
+	} {
+		if redirectIfNotMarkdown(uri) {
+			t.Errorf("redirectIfNotMarkdown(\"%s\") should return false.", uri)
+		}
+	}
+}
@@ -3,7 +3,12 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/alecthomas/chroma/v2/styles"
 )
@@ -15,6 +20,17 @@ const (
 	DEFAULT           = LIGHT_THEME
 	DEFAULT_CODESTYLE = "monokai"
 
+	LOCAL_RENDERER  = "local"
+	GITHUB_RENDERER = "github"
+
+	DEFAULT_RENDERER     = LOCAL_RENDERER
+	DEFAULT_RENDERER_URL = "https://api.github.com"
+
+	// Listen addresses starting with this prefix are paths to unix
+	// sockets, e.g. "unix:/run/spamd/alice.sock".
+	UNIX_PREFIX         = "unix:"
+	DEFAULT_SOCKET_MODE = os.FileMode(0600)
+
 	invalid_config_error = `The Json config file is poorly formatted.
 Please check your config file again.
 
@@ -22,7 +38,11 @@ An example config file would look as such:
 {
 	"theme": "dark",
 	"codeblock": "monokai",
-	"port": 3000
+	"port": 3000,
+	"extensions": {
+		"unsafe": false,
+		"typographer": true
+	}
 }
 
 NOTE: the last line does not have a trailing comma.
@@ -30,6 +50,49 @@ NOTE: the last line does not have a trailing comma.
 `
 )
 
+var (
+	// File extensions recognized as markdowns (case-insensitive)
+	// if "markdown_extensions" is not set in the config file.
+	DefaultMarkdownExtensions = []string{".md", ".markdown", ".mdx", ".mkd"}
+
+	// Names of the markdown extensions that can be toggled in the
+	// "extensions" section of the config file.
+	ExtensionNames = []string{
+		"table",
+		"strikethrough",
+		"linkify",
+		"tasklist",
+		"footnote",
+		"highlighting",
+		"emoji",
+		"unsafe", // Render raw HTML as is.
+		"autoheadingid",
+		"definitionlist",
+		"typographer",
+		"cjk",
+		"attribute",
+	}
+
+	// CSP source expressions allowed in "frame_ancestors": 'self',
+	// 'none', schemes (e.g. "vscode-webview:") and hosts with an
+	// optional scheme, port and path (e.g. "https://*.example.com:8443").
+	frameAncestorRegex = regexp.MustCompile(`^(?:'self'|'none'|[a-zA-Z][a-zA-Z0-9+.-]*:|(?:[a-zA-Z][a-zA-Z0-9+.-]*://)?(?:\*|(?:\*\.)?[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*)(?::(?:[0-9]+|\*))?(?:/[a-zA-Z0-9._~%!$&()*+=@/-]*)?)$`)
+
+	// Whether each extension is enabled if it is not set in the
+	// config file. Omitted extensions are disabled by default.
+	defaultExtensions = map[string]bool{
+		"table":         true,
+		"strikethrough": true,
+		"linkify":       true,
+		"tasklist":      true,
+		"footnote":      true,
+		"highlighting":  true,
+		"emoji":         true,
+		"unsafe":        true,
+		"autoheadingid": true,
+	}
+)
+
 func IsChromaTheme(theme string) bool {
 	for _, th := range styles.Names() {
 		if th == theme {
@@ -40,10 +103,136 @@ func IsChromaTheme(theme string) bool {
 	return false
 }
 
+func IsExtension(name string) bool {
+	for _, ext := range ExtensionNames {
+		if ext == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 type ServiceConfig struct {
-	Theme          string `json:"theme"`
-	CodeBlockTheme string `json:"codeblock"`
-	Port           int    `json:"port"` // Defaults to 0 if not set.
+	Theme          string          `json:"theme"`
+	CodeBlockTheme string          `json:"codeblock"`
+	Port           int             `json:"port"`       // Defaults to 0 if not set.
+	Extensions     map[string]bool `json:"extensions"` // Overrides defaultExtensions.
+	Safe           bool            `json:"safe"`       // Sanitize raw HTML in rendered markdown.
+
+	// Listens at the next free port (or a random one) if Port is busy,
+	// instead of failing.
+	PortFallback bool `json:"port_fallback"`
+
+	// Sources allowed to embed the pages in a frame (e.g. "vscode-webview:"
+	// for an IDE webview). No one is allowed by default.
+	FrameAncestors []string `json:"frame_ancestors"`
+
+	// File extensions recognized as markdowns. Defaults to
+	// DefaultMarkdownExtensions if empty.
+	MarkdownExtensions []string `json:"markdown_extensions"`
+
+	// Renders markdowns with goldmark ("local") or a Github-compatible
+	// /markdown endpoint ("github") located at RendererURL. $GITHUB_TOKEN
+	// is only sent to the Github API (DEFAULT_RENDERER_URL) over https.
+	Renderer    string `json:"renderer"`
+	RendererURL string `json:"renderer_url"`
+
+	// Listens at this unix socket ("unix:/path/to.sock") instead of
+	// a TCP port, with the permissions in SocketMode (octal, e.g.
+	// "0660"). Defaults to DEFAULT_SOCKET_MODE.
+	Listen     string `json:"listen"`
+	SocketMode string `json:"socket_mode"`
+
+	// Path the server is mounted at behind a reverse proxy, e.g.
+	// "/preview/alice". Overridden by the X-Forwarded-Prefix header.
+	BasePath string `json:"base_path"`
+
+	// Honors the X-Forwarded-* headers of a reverse proxy in front of
+	// a TCP port at the root path. They are also honored if Listen or
+	// BasePath is set (see BehindProxy), and ignored otherwise, since
+	// any client could send them.
+	TrustProxy bool `json:"trust_proxy"`
+
+	// Serves Prometheus metrics (render latencies, websocket
+	// connections) at MetricsPrefix.
+	Metrics bool `json:"metrics"`
+
+	// Command opening the markdowns, with {url} replaced by the URL of
+	// each (e.g. "firefox --new-window {url}"), or {urls} by all of them
+	// to open them in a single window. Defaults to $BROWSER, and then
+	// to the default browser of the platform.
+	Browser string `json:"browser"`
+}
+
+// Returns true if filepath is named like a markdown, i.e. it has one
+// of the markdown extensions or it is a README without extension.
+// Both are matched case-insensitively (README.MD is a markdown).
+func (conf *ServiceConfig) IsMarkdown(filepath string) bool {
+	name := path.Base(filepath)
+	ext := path.Ext(name)
+	if ext == "" {
+		return strings.EqualFold(name, "README")
+	}
+
+	exts := conf.MarkdownExtensions
+	if len(exts) == 0 {
+		exts = DefaultMarkdownExtensions
+	}
+	for _, e := range exts {
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (conf *ServiceConfig) ExtensionEnabled(name string) bool {
+	if enabled, ok := conf.Extensions[name]; ok {
+		return enabled
+	}
+
+	return defaultExtensions[name]
+}
+
+// Returns the names of all enabled extensions (in the order
+// of ExtensionNames).
+func (conf *ServiceConfig) EnabledExtensions() []string {
+	var enabled []string
+	for _, name := range ExtensionNames {
+		if conf.ExtensionEnabled(name) {
+			enabled = append(enabled, name)
+		}
+	}
+
+	return enabled
+}
+
+// Returns an error listing the available extensions if
+// any extension in the config is unknown.
+func (conf *ServiceConfig) ValidateExtensions() error {
+	for name := range conf.Extensions {
+		if IsExtension(name) {
+			continue
+		}
+
+		message := fmt.Sprintf("Unknown extension \"%s\". The following extensions are available:\n\n", name)
+		for _, ext := range ExtensionNames {
+			state := "off"
+			if defaultExtensions[ext] {
+				state = "on"
+			}
+			message += fmt.Sprintf("	%-16s(default: %s)\n", ext, state)
+		}
+
+		return errors.New(message)
+	}
+
+	return nil
 }
 
 func (conf *ServiceConfig) SetTheme(theme string) {
@@ -74,6 +263,99 @@ func (conf *ServiceConfig) SetCodeBlockTheme(codeBlockStyle string) error {
 	return nil
 }
 
+func (conf *ServiceConfig) SetRenderer(renderer string) error {
+	// User didn't supply option (default is "")
+	if len(renderer) == 0 {
+		return nil
+	}
+
+	if renderer != LOCAL_RENDERER && renderer != GITHUB_RENDERER {
+		return fmt.Errorf("Unknown renderer \"%s\". Use either \"%s\" or \"%s\".", renderer, LOCAL_RENDERER, GITHUB_RENDERER)
+	}
+
+	conf.Renderer = renderer
+	return nil
+}
+
+func (conf *ServiceConfig) SetListen(address string) error {
+	// User didn't supply option (default is "")
+	if len(address) == 0 {
+		return nil
+	}
+
+	if !strings.HasPrefix(address, UNIX_PREFIX) || len(address) == len(UNIX_PREFIX) {
+		return fmt.Errorf("Unknown listen address \"%s\". Use \"%s/path/to.sock\" to listen at a unix socket.", address, UNIX_PREFIX)
+	}
+
+	conf.Listen = address
+	return nil
+}
+
+func (conf *ServiceConfig) SetSocketMode(mode string) error {
+	// User didn't supply option (default is "")
+	if len(mode) == 0 {
+		return nil
+	}
+
+	if perm, err := strconv.ParseUint(mode, 8, 32); err != nil || perm > 0777 {
+		return fmt.Errorf("Invalid socket mode \"%s\". Use octal permissions, e.g. \"0660\".", mode)
+	}
+
+	conf.SocketMode = mode
+	return nil
+}
+
+// Returns the permissions of the unix socket (see Listen).
+func (conf *ServiceConfig) SocketPermissions() os.FileMode {
+	perm, err := strconv.ParseUint(conf.SocketMode, 8, 32)
+	if err != nil {
+		return DEFAULT_SOCKET_MODE
+	}
+	return os.FileMode(perm)
+}
+
+// Returns true if the server is reached through a reverse proxy,
+// whose X-Forwarded-* headers can then be trusted.
+func (conf *ServiceConfig) BehindProxy() bool {
+	return conf.TrustProxy || conf.Listen != "" || conf.BasePath != ""
+}
+
+// Returns an error if a source in FrameAncestors is not a CSP source
+// expression, e.g. one injecting other directives with ";".
+func (conf *ServiceConfig) ValidateFrameAncestors() error {
+	for _, source := range conf.FrameAncestors {
+		if !frameAncestorRegex.MatchString(source) {
+			return fmt.Errorf("Unknown frame ancestor \"%s\". Use a scheme (e.g. \"vscode-webview:\"), a host (e.g. \"https://*.example.com\") or 'self'.", source)
+		}
+	}
+
+	return nil
+}
+
+// Returns p as a base path: with a leading '/' and no trailing one,
+// or "" for the root.
+func CleanBasePath(p string) string {
+	p = path.Clean("/" + strings.Trim(p, "/"))
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+func (conf *ServiceConfig) SetBasePath(basePath string) error {
+	// User didn't supply option (default is "")
+	if len(basePath) == 0 {
+		return nil
+	}
+
+	if strings.ContainsAny(basePath, "?#") || strings.Contains(basePath, "://") {
+		return fmt.Errorf("Invalid base path \"%s\". Use a path, e.g. \"/preview/alice\".", basePath)
+	}
+
+	conf.BasePath = CleanBasePath(basePath)
+	return nil
+}
+
 func ReadConfigFromFile(configFilename string) (*ServiceConfig, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -88,6 +370,8 @@ func ReadConfigFromFile(configFilename string) (*ServiceConfig, error) {
 		return &ServiceConfig{
 			Theme:          DEFAULT,
 			CodeBlockTheme: DEFAULT_CODESTYLE,
+			Renderer:       DEFAULT_RENDERER,
+			RendererURL:    DEFAULT_RENDERER_URL,
 		}, nil
 	}
 
@@ -103,6 +387,35 @@ func ReadConfigFromFile(configFilename string) (*ServiceConfig, error) {
 	if conf.CodeBlockTheme == "" || !IsChromaTheme(conf.CodeBlockTheme) {
 		conf.CodeBlockTheme = DEFAULT_CODESTYLE
 	}
+	if err := conf.ValidateExtensions(); err != nil {
+		return nil, err
+	}
+	if err := conf.ValidateFrameAncestors(); err != nil {
+		return nil, err
+	}
+
+	renderer := conf.Renderer
+	conf.Renderer = DEFAULT_RENDERER
+	if err := conf.SetRenderer(renderer); err != nil {
+		return nil, err
+	}
+	if conf.RendererURL == "" {
+		conf.RendererURL = DEFAULT_RENDERER_URL
+	}
+
+	listen, mode := conf.Listen, conf.SocketMode
+	conf.Listen, conf.SocketMode = "", ""
+	if err := conf.SetListen(listen); err != nil {
+		return nil, err
+	}
+	if err := conf.SetSocketMode(mode); err != nil {
+		return nil, err
+	}
+	basePath := conf.BasePath
+	conf.BasePath = ""
+	if err := conf.SetBasePath(basePath); err != nil {
+		return nil, err
+	}
 
 	return &conf, nil
 }
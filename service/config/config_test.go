@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/alecthomas/chroma/v2/styles"
@@ -181,3 +182,174 @@ func TestErrorOnInvalidConfigFile(t *testing.T) {
 		t.Error("Should return error on invalid config file.")
 	}
 }
+
+func TestDefaultExtensions(t *testing.T) {
+	testConfig := ServiceConfig{}
+
+	if !testConfig.ExtensionEnabled("unsafe") {
+		t.Error("unsafe should be enabled by default.")
+	}
+	if testConfig.ExtensionEnabled("typographer") {
+		t.Error("typographer should be disabled by default.")
+	}
+}
+
+func TestOverrideExtensions(t *testing.T) {
+	testConfig := ServiceConfig{
+		Extensions: map[string]bool{
+			"unsafe":      false,
+			"typographer": true,
+		},
+	}
+
+	if testConfig.ExtensionEnabled("unsafe") {
+		t.Error("unsafe should be disabled.")
+	}
+	if !testConfig.ExtensionEnabled("typographer") {
+		t.Error("typographer should be enabled.")
+	}
+	for _, name := range testConfig.EnabledExtensions() {
+		if name == "unsafe" {
+			t.Error("unsafe should not be listed as enabled.")
+		}
+	}
+}
+
+func TestErrorOnUnknownExtension(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	file, _ := os.CreateTemp(home, ".*")
+	file.WriteString("{\"extensions\":{\"nosuchextension\":true}}")
+	defer os.Remove(file.Name())
+
+	configFilename := path.Base(file.Name()[1:])
+	conf, err := ReadConfigFromFile(configFilename)
+	if conf != nil {
+		t.Error("Should return <nil> on unknown extension.")
+	}
+	if err == nil || !strings.Contains(err.Error(), "typographer") {
+		t.Errorf("got %v; want error listing available extensions", err)
+	}
+}
+
+func TestSetRenderer(t *testing.T) {
+	testConfig := ServiceConfig{
+		Renderer: "local",
+	}
+
+	if err := testConfig.SetRenderer(""); err != nil || testConfig.Renderer != "local" {
+		t.Errorf("got %s (%v); want local", testConfig.Renderer, err)
+	}
+	if err := testConfig.SetRenderer("github"); err != nil || testConfig.Renderer != "github" {
+		t.Errorf("got %s (%v); want github", testConfig.Renderer, err)
+	}
+	if err := testConfig.SetRenderer("gitlab"); err == nil || testConfig.Renderer != "github" {
+		t.Errorf("got %s (%v); want github with error", testConfig.Renderer, err)
+	}
+}
+
+func TestSetListenAndSocketMode(t *testing.T) {
+	testConfig := ServiceConfig{}
+
+	if err := testConfig.SetListen("unix:/run/spamd.sock"); err != nil || testConfig.Listen != "unix:/run/spamd.sock" {
+		t.Errorf("got %s (%v); want unix:/run/spamd.sock", testConfig.Listen, err)
+	}
+	for _, address := range []string{"unix:", "localhost:3000"} {
+		if err := testConfig.SetListen(address); err == nil {
+			t.Errorf("SetListen(%s) got <nil>; want error", address)
+		}
+	}
+
+	if perm := testConfig.SocketPermissions(); perm != DEFAULT_SOCKET_MODE {
+		t.Errorf("got %o; want %o", perm, DEFAULT_SOCKET_MODE)
+	}
+	if err := testConfig.SetSocketMode("0660"); err != nil || testConfig.SocketPermissions() != 0660 {
+		t.Errorf("got %o (%v); want 660", testConfig.SocketPermissions(), err)
+	}
+	for _, mode := range []string{"rw", "0999", "1777"} {
+		if err := testConfig.SetSocketMode(mode); err == nil {
+			t.Errorf("SetSocketMode(%s) got <nil>; want error", mode)
+		}
+	}
+}
+
+func TestSetBasePath(t *testing.T) {
+	testConfig := ServiceConfig{}
+
+	for basePath, want := range map[string]string{
+		"/preview/alice/": "/preview/alice",
+		"preview":         "/preview",
+		"/":               "",
+	} {
+		if err := testConfig.SetBasePath(basePath); err != nil || testConfig.BasePath != want {
+			t.Errorf("SetBasePath(%s) got %s (%v); want %s", basePath, testConfig.BasePath, err, want)
+		}
+	}
+	if err := testConfig.SetBasePath("https://devbox/preview"); err == nil {
+		t.Error("got <nil>; want error on URL")
+	}
+}
+
+func TestValidateFrameAncestors(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected bool
+	}{
+		{"'self'", true},
+		{"'none'", true},
+		{"vscode-webview:", true},
+		{"https://*.example.com:8443", true},
+		{"example.com/embed/", true},
+		{"http://localhost:*", true},
+		{"'self'; script-src *", false},
+		{"https://example.com\nscript-src *", false},
+		{"https://example.com https://evil.com", false},
+		{"'unsafe-inline'", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		conf := ServiceConfig{FrameAncestors: []string{c.source}}
+		if err := conf.ValidateFrameAncestors(); (err == nil) != c.expected {
+			t.Errorf("%q: got %v; want valid %t", c.source, err, c.expected)
+		}
+	}
+}
+
+func TestBehindProxy(t *testing.T) {
+	cases := []struct {
+		conf     ServiceConfig
+		expected bool
+	}{
+		{ServiceConfig{}, false},
+		{ServiceConfig{Port: 3000}, false},
+		{ServiceConfig{TrustProxy: true}, true},
+		{ServiceConfig{Listen: "unix:/run/spamd/docs.sock"}, true},
+		{ServiceConfig{BasePath: "/preview"}, true},
+	}
+
+	for _, c := range cases {
+		if got := c.conf.BehindProxy(); got != c.expected {
+			t.Errorf("%+v: got %t; want %t", c.conf, got, c.expected)
+		}
+	}
+}
+
+func TestIsMarkdown(t *testing.T) {
+	testConfig := ServiceConfig{}
+
+	for _, filepath := range []string{"a.md", "docs/a.markdown", "a.mdx", "a.mkd", "README.MD", "README", "docs/readme"} {
+		if !testConfig.IsMarkdown(filepath) {
+			t.Errorf("IsMarkdown(%s) got false; want true", filepath)
+		}
+	}
+	for _, filepath := range []string{"a.go", "a.md.go", "LICENSE", "md"} {
+		if testConfig.IsMarkdown(filepath) {
+			t.Errorf("IsMarkdown(%s) got true; want false", filepath)
+		}
+	}
+
+	testConfig.MarkdownExtensions = []string{".md", "txt"}
+	if !testConfig.IsMarkdown("notes.TXT") || testConfig.IsMarkdown("a.mdx") {
+		t.Errorf("got %v; want only .md and .txt recognized", testConfig.MarkdownExtensions)
+	}
+}
@@ -5,6 +5,14 @@ import "fmt"
 const (
 	RefreshPrefix = "/__/refresh"
 	StylesPrefix  = "/__/styles"
+	ScriptsPrefix = "/__/scripts"
+	StatusPrefix  = "/__/status"
+	MetricsPrefix = "/__/metrics" // Only if enabled.
+	StopPrefix    = "/__/stop"
+
+	// Requests controlling the server (e.g. to stop it) must carry
+	// the token recorded in its lockfile in this header.
+	TokenHeader = "X-Spamd-Token"
 
 	// Matches these image types.
 	ImageRegex = "^\\/.+.(png|jpg|gif|jpeg|svg)$"
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"spamd/internal/instance"
+	"spamd/internal/options"
+	"spamd/internal/sys"
+	"spamd/service/config"
+)
+
+const control_timeout = 2 * time.Second
+
+// Lockfile of this server (see internal/instance), set once it listens.
+var self *instance.Instance
+
+// A server found in its lockfile, which answered with its status.
+type runningInstance struct {
+	instance.Instance
+	Status status
+}
+
+// Returns the current directory with symlinks resolved, so that
+// servers started from different links to it are told apart.
+func workingDir() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(cwd); err == nil {
+		return resolved
+	}
+	return cwd
+}
+
+// Records this server, listening at baseUrl, for other invocations.
+func registerInstance(baseUrl, version string) {
+	token, err := instance.NewToken()
+	if err != nil {
+		slog.Warn("Failed to record the server. Other invocations will not find it.", "error", err)
+		return
+	}
+
+	inst := instance.Instance{
+		PID:     os.Getpid(),
+		Address: baseUrl,
+		Dir:     workingDir(),
+		Token:   token,
+		Version: version,
+		Started: time.Now(),
+	}
+	if err := instance.Register(inst); err != nil {
+		slog.Warn("Failed to record the server. Other invocations will not find it.", "error", err)
+		return
+	}
+	self = &inst
+}
+
+func unregisterInstance() {
+	if self != nil {
+		instance.Unregister(self.PID)
+	}
+}
+
+// Returns a client for the server at address, with the base URL of
+// its requests. Servers at unix sockets are dialed at their socket.
+func controlClient(address string) (*http.Client, string) {
+	client := &http.Client{Timeout: control_timeout}
+	if !strings.HasPrefix(address, config.UNIX_PREFIX) {
+		return client, address
+	}
+
+	socket := strings.TrimPrefix(address, config.UNIX_PREFIX)
+	client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return client, protocol + "unix"
+}
+
+// Returns the status of the server recorded in inst, or an error if
+// it is not running.
+func fetchStatus(inst instance.Instance) (status, error) {
+	var st status
+	client, base := controlClient(inst.Address)
+	resp, err := client.Get(base + config.StatusPrefix)
+	if err != nil {
+		return st, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("%s returned %s", inst.Address, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	return st, err
+}
+
+// Returns true if the server recorded in inst, whose status request
+// failed with err, is no longer running: its process has exited or
+// nothing listens at its address anymore.
+func stopped(inst instance.Instance, err error) bool {
+	return instance.Exited(inst.PID) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist)
+}
+
+// Returns the running servers, removing the lockfiles of servers
+// that are gone (e.g. killed before they could remove them).
+func runningInstances() []runningInstance {
+	instances, err := instance.List()
+	if err != nil {
+		slog.Warn("Failed to read the running servers", "dir", instance.Dir(), "error", err)
+		return nil
+	}
+
+	var running []runningInstance
+	for _, inst := range instances {
+		st, err := fetchStatus(inst)
+		if err != nil {
+			// Servers that are busy (or slow to answer) keep their
+			// lockfile, so that they can still be found and stopped.
+			if inst.PID != os.Getpid() && stopped(inst, err) {
+				slog.Debug("Removing lockfile of a stopped server", "pid", inst.PID, "error", err)
+				instance.Unregister(inst.PID)
+			}
+			continue
+		}
+		running = append(running, runningInstance{inst, st})
+	}
+	return running
+}
+
+// Returns the status of the spamd server at port, or nil if port is
+// not used by spamd.
+func spamdAt(port int) *status {
+	st, err := fetchStatus(instance.Instance{Address: fmt.Sprintf("%slocalhost:%d", protocol, port)})
+	if err != nil || st.Version == "" {
+		return nil
+	}
+	return &st
+}
+
+// Returns the server running in dir, or nil if there is none.
+func instanceIn(dir string) *runningInstance {
+	for _, r := range runningInstances() {
+		if r.Dir == dir {
+			return &r
+		}
+	}
+	return nil
+}
+
+// Stops the server once the response is sent. Requests must carry
+// the token of the server (see config.TokenHeader).
+func serveStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.Header.Get(config.TokenHeader)
+	if self == nil || subtle.ConstantTimeCompare([]byte(token), []byte(self.Token)) != 1 {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		Shutdown()
+		os.Exit(0)
+	}()
+}
+
+func stopInstance(inst instance.Instance) error {
+	client, base := controlClient(inst.Address)
+	req, err := http.NewRequest(http.MethodPost, base+config.StopPrefix, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(config.TokenHeader, inst.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("%s refused to stop (%s).", inst.Address, resp.Status)
+	}
+	return nil
+}
+
+// Runs "spamd list": prints each running server with the files
+// opened in it.
+func List(opts *options.Options) {
+	running := runningInstances()
+	if len(running) == 0 {
+		fmt.Println("No spamd server is running.")
+		return
+	}
+
+	for _, r := range running {
+		fmt.Printf("%s (pid %d) in %s\n", r.Address, r.PID, r.Dir)
+		for _, file := range r.Status.Files {
+			fmt.Printf("  %s (%d tabs)\n", file.Path, file.Connections)
+		}
+	}
+}
+
+// Runs "spamd stop": stops the server running in the current
+// directory, or all of them.
+func Stop(opts *options.Options) {
+	dir := workingDir()
+	stopped, failed := 0, 0
+	for _, r := range runningInstances() {
+		if !opts.Stop.All && r.Dir != dir {
+			continue
+		}
+
+		if err := stopInstance(r.Instance); err != nil {
+			sys.Eprintf("%s\n", err)
+			failed++
+			continue
+		}
+		fmt.Printf("Stopped %s (pid %d) in %s\n", r.Address, r.PID, r.Dir)
+		stopped++
+	}
+
+	switch {
+	case failed > 0:
+		sys.ErrorAndExit("")
+	case stopped == 0 && opts.Stop.All:
+		sys.ErrorAndExit("No spamd server is running.")
+	case stopped == 0:
+		sys.ErrorAndExit("No spamd server is running in this directory.")
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"spamd/internal/instance"
+	testtools "spamd/internal/testing"
+	"spamd/service/config"
+)
+
+func TestRunningInstances(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	watcher = newFileWatcher(true)
+
+	s := httptest.NewServer(http.HandlerFunc(serveStatus))
+	defer s.Close()
+	closed := httptest.NewServer(http.HandlerFunc(serveStatus))
+	closed.Close()
+
+	running := instance.Instance{PID: 1, Address: s.URL, Dir: "/docs", Started: time.Unix(1, 0)}
+	stopped := instance.Instance{PID: 2, Address: closed.URL, Dir: "/notes", Started: time.Unix(2, 0)}
+	instance.Register(running)
+	instance.Register(stopped)
+
+	got := instanceIn("/docs")
+	if got == nil || got.Address != s.URL || got.Status.Config == nil {
+		t.Errorf("got %v; want the server at %s", got, s.URL)
+	}
+	if got := instanceIn("/notes"); got != nil {
+		t.Errorf("got %v; want <nil> for a stopped server", got)
+	}
+
+	// The lockfile of the stopped server is removed.
+	if instances, _ := instance.List(); len(instances) != 1 || instances[0].PID != running.PID {
+		t.Errorf("got %v; want %v only", instances, running)
+	}
+}
+
+func TestRunningInstancesKeepsBusyServers(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+
+	// The parent process (go test) is alive, but its server fails to answer.
+	alive := instance.Instance{PID: os.Getppid(), Address: busy.URL, Dir: "/docs"}
+	instance.Register(alive)
+
+	if running := runningInstances(); len(running) != 0 {
+		t.Errorf("got %v; want no running server", running)
+	}
+	if instances, _ := instance.List(); len(instances) != 1 || instances[0].PID != alive.PID {
+		t.Errorf("got %v; want %v to be kept", instances, alive)
+	}
+}
+
+func TestServeStopRequiresToken(t *testing.T) {
+	self = &instance.Instance{Token: "secret"}
+	defer func() { self = nil }()
+
+	rr := testtools.MockRequest(t, "GET", config.StopPrefix, http.HandlerFunc(serveStop))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got %d; want %d", rr.Code, http.StatusMethodNotAllowed)
+	}
+
+	req := httptest.NewRequest("POST", config.StopPrefix, nil)
+	req.Header.Set(config.TokenHeader, "guess")
+	rr = httptest.NewRecorder()
+	serveStop(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got %d; want %d", rr.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// Returns the converter of delimited values (comma or tab separated)
+// into a table. The first row is the header. Sorting and filtering
+// of the rows is done in the frontend.
+//
+// Malformed rows are reported (by line) above the table, along with
+// the rows that could be read, like on Github.
+func convertDelimited(comma rune) func(key string, source []byte) ([]byte, error) {
+	return func(key string, source []byte) ([]byte, error) {
+		return convertCached(key, source, func(source []byte) ([]byte, error) {
+			source = bytes.TrimPrefix(source, []byte("\ufeff"))
+
+			read := readCommaSeparated
+			if comma == '\t' {
+				read = readTabSeparated
+			}
+			rows, lines, parseErrors := read(source)
+
+			var content bytes.Buffer
+			content.WriteString(`<div class="csv">`)
+			writeParseErrors(&content, parseErrors)
+			writeTable(&content, rows, lines)
+			content.WriteString(`</div>`)
+			return content.Bytes(), nil
+		})
+	}
+}
+
+// Returns the records (and the lines they start at) that could be
+// read, along with the errors of those that could not.
+func readCommaSeparated(source []byte) ([][]string, []int, []error) {
+	var rows [][]string
+	var lines []int
+	var parseErrors []error
+
+	reader := csv.NewReader(bytes.NewReader(source))
+	for {
+		offset := reader.InputOffset()
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			parseErrors = append(parseErrors, err)
+			// Records with a wrong number of fields are still returned.
+			if !errors.Is(err, csv.ErrFieldCount) {
+				if reader.InputOffset() == offset {
+					break
+				}
+				continue
+			}
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, record)
+		lines = append(lines, line)
+	}
+
+	return rows, lines, parseErrors
+}
+
+// Like readCommaSeparated, but quotes are not special in tab separated
+// values, so each line is a record.
+func readTabSeparated(source []byte) ([][]string, []int, []error) {
+	var rows [][]string
+	var lines []int
+	var parseErrors []error
+
+	for i, line := range strings.Split(string(source), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+
+		record := strings.Split(line, "\t")
+		if len(rows) > 0 && len(record) != len(rows[0]) {
+			parseErrors = append(parseErrors, &csv.ParseError{StartLine: i + 1, Line: i + 1, Column: 1, Err: csv.ErrFieldCount})
+		}
+		rows = append(rows, record)
+		lines = append(lines, i+1)
+	}
+
+	return rows, lines, parseErrors
+}
+
+func writeParseErrors(w *bytes.Buffer, parseErrors []error) {
+	if len(parseErrors) == 0 {
+		return
+	}
+
+	w.WriteString(`<div class="csv-errors"><p>This file could not be fully parsed:</p><ul>`)
+	for _, err := range parseErrors {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			fmt.Fprintf(w, "<li>Line %d, column %d: %s</li>", parseErr.StartLine, parseErr.Column, html.EscapeString(parseErr.Err.Error()))
+		} else {
+			fmt.Fprintf(w, "<li>%s</li>", html.EscapeString(err.Error()))
+		}
+	}
+	w.WriteString(`</ul></div>`)
+}
+
+// Writes rows as a table, each row linkable with the
+// line (in lines) it starts at, e.g. #L10.
+func writeTable(w *bytes.Buffer, rows [][]string, lines []int) {
+	if len(rows) == 0 {
+		w.WriteString(`<p>This file is empty.</p>`)
+		return
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	w.WriteString("<table>\n<thead>\n")
+	writeTableRow(w, "th", rows[0], columns, lines[0])
+	w.WriteString("</thead>\n<tbody>\n")
+	for i := 1; i < len(rows); i++ {
+		writeTableRow(w, "td", rows[i], columns, lines[i])
+	}
+	w.WriteString("</tbody>\n</table>")
+}
+
+func writeTableRow(w *bytes.Buffer, tag string, row []string, columns int, line int) {
+	fmt.Fprintf(w, `<tr id="L%d">`, line)
+	for i := 0; i < columns; i++ {
+		cell := ""
+		if i < len(row) {
+			cell = html.EscapeString(row[i])
+		}
+		fmt.Fprintf(w, "<%s>%s</%s>", tag, cell, tag)
+	}
+	w.WriteString("</tr>\n")
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertCSV(t *testing.T) {
+	source := "\ufeffname,age\nalice,30\n\"bob, jr\",<b>\n"
+
+	got, err := convertDelimited(',')("test.csv", []byte(source))
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	for _, want := range []string{
+		`<tr id="L1"><th>name</th><th>age</th></tr>`,
+		`<tr id="L2"><td>alice</td><td>30</td></tr>`,
+		`<tr id="L3"><td>bob, jr</td><td>&lt;b&gt;</td></tr>`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+	if strings.Contains(string(got), "csv-errors") {
+		t.Errorf("got %s; want no errors", got)
+	}
+}
+
+func TestConvertTSV(t *testing.T) {
+	got, _ := convertDelimited('\t')("test.tsv", []byte("a\tb\n\"x\ty\n"))
+
+	if want := `<tr id="L2"><td>&#34;x</td><td>y</td></tr>`; !strings.Contains(string(got), want) {
+		t.Errorf("got %s; want to contain %s", got, want)
+	}
+}
+
+func TestReportCSVErrorsByLine(t *testing.T) {
+	source := "a,b\n1,2,3\n4,5\n6,\"7\n"
+
+	got, _ := convertDelimited(',')("test.csv", []byte(source))
+
+	for _, want := range []string{
+		"<li>Line 2, column 1: wrong number of fields</li>",
+		"<li>Line 4, column",
+		// Rows that could be read are still shown.
+		`<tr id="L2"><td>1</td><td>2</td><td>3</td></tr>`,
+		`<tr id="L3"><td>4</td><td>5</td><td></td></tr>`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+}
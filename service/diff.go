@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"spamd/internal/git"
+)
+
+const (
+	// Query parameter holding the revision to diff against.
+	diff_param = "diff"
+	// Revision used if the parameter has no value.
+	default_diff_ref = "HEAD"
+)
+
+type diffOp int
+
+const (
+	diff_equal diffOp = iota
+	diff_removed
+	diff_inserted
+)
+
+type diffBlock struct {
+	op   diffOp
+	html string
+}
+
+// Splits converted HTML into its top-level elements, so that
+// changes are shown per paragraph/heading/list/code block etc.
+func splitBlocks(content []byte) ([]string, error) {
+	nodes, err := html.ParseFragment(bytes.NewReader(content), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []string
+	for _, node := range nodes {
+		if node.Type == html.TextNode && strings.TrimSpace(node.Data) == "" {
+			continue
+		}
+
+		var block bytes.Buffer
+		if err := html.Render(&block, node); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block.String())
+	}
+
+	return blocks, nil
+}
+
+// Returns the changes from old to new (based on their longest
+// common subsequence), where removals precede insertions.
+func diffBlocks(old []string, new []string) []diffBlock {
+	// lcs[i][j] is the length of the LCS of old[i:] and new[j:].
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var blocks []diffBlock
+	i, j := 0, 0
+	for i < len(old) && j < len(new) {
+		switch {
+		case old[i] == new[j]:
+			blocks = append(blocks, diffBlock{diff_equal, old[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			blocks = append(blocks, diffBlock{diff_removed, old[i]})
+			i++
+		default:
+			blocks = append(blocks, diffBlock{diff_inserted, new[j]})
+			j++
+		}
+	}
+	for ; i < len(old); i++ {
+		blocks = append(blocks, diffBlock{diff_removed, old[i]})
+	}
+	for ; j < len(new); j++ {
+		blocks = append(blocks, diffBlock{diff_inserted, new[j]})
+	}
+
+	return blocks
+}
+
+// Lays out the changes side by side: the revision on the left and
+// the working copy on the right. Consecutive removals and insertions
+// share a row, so that replaced blocks line up.
+func writeDiff(w *bytes.Buffer, ref string, blocks []diffBlock) {
+	w.WriteString(`<div class="diff">`)
+	fmt.Fprintf(w, `<div class="diff-row diff-header"><div class="diff-old">%s</div><div class="diff-new">Working copy</div></div>`,
+		html.EscapeString(ref))
+
+	changed := false
+	for i := 0; i < len(blocks); {
+		if blocks[i].op == diff_equal {
+			fmt.Fprintf(w, `<div class="diff-row"><div class="diff-old">%s</div><div class="diff-new">%s</div></div>`,
+				blocks[i].html, blocks[i].html)
+			i++
+			continue
+		}
+
+		changed = true
+		var removed, inserted strings.Builder
+		for ; i < len(blocks) && blocks[i].op != diff_equal; i++ {
+			if blocks[i].op == diff_removed {
+				removed.WriteString(blocks[i].html)
+			} else {
+				inserted.WriteString(blocks[i].html)
+			}
+		}
+
+		w.WriteString(`<div class="diff-row">`)
+		writeDiffSide(w, "diff-old", "diff-removed", removed.String())
+		writeDiffSide(w, "diff-new", "diff-inserted", inserted.String())
+		w.WriteString(`</div>`)
+	}
+
+	if !changed {
+		fmt.Fprintf(w, `<div class="diff-row diff-unchanged">No changes against %s.</div>`, html.EscapeString(ref))
+	}
+	w.WriteString(`</div>`)
+}
+
+func writeDiffSide(w *bytes.Buffer, side string, change string, content string) {
+	if content == "" {
+		fmt.Fprintf(w, `<div class="%s"></div>`, side)
+		return
+	}
+
+	fmt.Fprintf(w, `<div class="%s %s">%s</div>`, side, change, content)
+}
+
+// Returns the rendered diff of the document at filepath between
+// revision ref and the working copy. A file missing from ref is
+// shown as entirely inserted.
+func renderDiff(filepath string, ref string) ([]byte, error) {
+	format := formatOf(filepath)
+	if format == nil {
+		return nil, fmt.Errorf("%s is not a document.", filepath)
+	}
+
+	source, err := git.Show(ref, filepath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Cached separately from the working copy.
+	old, err := format.convert(ref+":"+filepath, source)
+	if err != nil {
+		return nil, err
+	}
+	new, err := renderFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	oldBlocks, err := splitBlocks(old)
+	if err != nil {
+		return nil, err
+	}
+	newBlocks, err := splitBlocks(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	writeDiff(&content, ref, diffBlocks(oldBlocks, newBlocks))
+	return content.Bytes(), nil
+}
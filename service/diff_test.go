@@ -0,0 +1,76 @@
+package service
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitBlocks(t *testing.T) {
+	content := []byte(`<h1 id="header">Header</h1>
+<p>First <b>paragraph</b>.</p>
+<ul>
+<li>item</li>
+</ul>
+`)
+
+	got, err := splitBlocks(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`<h1 id="header">Header</h1>`,
+		`<p>First <b>paragraph</b>.</p>`,
+		"<ul>\n<li>item</li>\n</ul>",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestDiffBlocks(t *testing.T) {
+	old := []string{"a", "b", "c", "d"}
+	new := []string{"a", "c", "x", "d", "e"}
+
+	got := diffBlocks(old, new)
+	want := []diffBlock{
+		{diff_equal, "a"},
+		{diff_removed, "b"},
+		{diff_equal, "c"},
+		{diff_inserted, "x"},
+		{diff_equal, "d"},
+		{diff_inserted, "e"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestRenderDiffOfUntrackedFile(t *testing.T) {
+	// Not part of any revision, so everything is inserted.
+	file, _ := os.CreateTemp(".", "*.md")
+	file.WriteString("# Header")
+	defer os.Remove(file.Name())
+
+	got, err := renderDiff(file.Name()[2:], "HEAD")
+	if err != nil {
+		t.Skipf("Not run inside a git repository. %s", err)
+	}
+
+	want := `<div class="diff-new diff-inserted"><h1 id="header">Header</h1></div>`
+	if !strings.Contains(string(got), want) {
+		t.Errorf("got %s; want to contain %s", got, want)
+	}
+}
+
+func TestRenderDiffOnInvalidRevision(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.md")
+	defer os.Remove(file.Name())
+
+	_, err := renderDiff(file.Name()[2:], "no-such-revision")
+	if err == nil {
+		t.Error("got <nil>; want error on invalid revision")
+	}
+}
@@ -0,0 +1,908 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	emojiast "github.com/yuin/goldmark-emoji/ast"
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+
+	"spamd/internal/options"
+	"spamd/internal/pdf"
+	"spamd/internal/sys"
+)
+
+// Layout of exported PDFs, in points.
+const (
+	pdf_margin      = 56
+	pdf_font_size   = 11
+	pdf_line_height = 1.5
+	pdf_block_gap   = 8
+	pdf_indent      = 20
+	pdf_cell_pad    = 5
+	pdf_code_pad    = 8
+)
+
+// Colors of the light theme.
+var (
+	pdfTextColor   = pdf.Color{R: 0x24, G: 0x29, B: 0x2f}
+	pdfMutedColor  = pdf.Color{R: 0x57, G: 0x60, B: 0x6a}
+	pdfLinkColor   = pdf.Color{R: 0x09, G: 0x69, B: 0xda}
+	pdfBorderColor = pdf.Color{R: 0xd0, G: 0xd7, B: 0xde}
+	pdfCodeColor   = pdf.Color{R: 0xf6, G: 0xf8, B: 0xfa}
+
+	pdfHeadingSizes = [...]float64{0, 22, 16.5, 13.75, 11, 9.5, 9}
+
+	// Page break hints: CSS page breaks (in raw HTML), and LaTeX
+	// style \newpage or \pagebreak paragraphs.
+	pageBreakRegex      = regexp.MustCompile(`(?i)(page-)?break-(before|after)\s*:\s*(always|page)|<!--\s*pagebreak\s*-->`)
+	latexPageBreakRegex = regexp.MustCompile(`^\\(newpage|pagebreak)$`)
+	lineBreakRegex      = regexp.MustCompile(`(?i)^<br\s*/?>$`)
+
+	pageSizes = map[string]pdf.Size{
+		"a4":     pdf.A4,
+		"letter": pdf.Letter,
+	}
+)
+
+type inlineStyle struct {
+	bold, italic, code, strike bool
+	size                       float64
+	color                      pdf.Color
+	link                       string
+}
+
+func (s inlineStyle) font() pdf.Font {
+	switch {
+	case s.code && s.bold:
+		return pdf.CourierBold
+	case s.code:
+		return pdf.Courier
+	case s.bold && s.italic:
+		return pdf.HelveticaBoldOblique
+	case s.bold:
+		return pdf.HelveticaBold
+	case s.italic:
+		return pdf.HelveticaOblique
+	}
+	return pdf.Helvetica
+}
+
+// A run of text in a single style, or an image (which is
+// written as a block of its own).
+type span struct {
+	inlineStyle
+	text  string
+	image *ast.Image
+}
+
+// A span placed at x (from the start of its line).
+type piece struct {
+	span
+	x, width float64
+}
+
+// Lays out a markdown (parsed by goldmark) on the pages of a PDF.
+type pdfExporter struct {
+	doc    *pdf.Document
+	page   *pdf.Page
+	y      float64
+	source []byte
+	// Directory of the markdown, which relative images are read from.
+	dir    string
+	style  *chroma.Style
+	images map[string]*pdf.Image
+
+	// Color of the text, muted in blockquotes.
+	color pdf.Color
+	// The x of the bar of each blockquote being written.
+	quoteBars []float64
+	// Drawn before the first line of the list item being written.
+	marker string
+}
+
+func newPDFExporter(filepath string, source []byte, size pdf.Size) *pdfExporter {
+	return &pdfExporter{
+		doc:    pdf.New(size),
+		source: source,
+		dir:    path.Dir(filepath),
+		style:  styles.Get(serviceConfig.CodeBlockTheme),
+		images: make(map[string]*pdf.Image),
+		color:  pdfTextColor,
+	}
+}
+
+// Writes the markdown at filepath as a PDF to w.
+func exportPDF(filepath string, w io.Writer, size pdf.Size) error {
+	if !isMarkdown(filepath) {
+		return fmt.Errorf("%s is not a markdown document.", filepath)
+	}
+	source, err := readSource(filepath)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %s", filepath, err)
+	}
+
+	e := newPDFExporter(filepath, source, size)
+	document := markdown.Get().Parser().Parse(text.NewReader(source))
+	e.doc.SetTitle(e.title(document, path.Base(filepath)))
+
+	e.newPage()
+	e.writeBlocks(document, pdf_margin, size.Width-2*pdf_margin)
+	e.writePageNumbers()
+
+	_, err = e.doc.WriteTo(w)
+	return err
+}
+
+// Runs "spamd export", which writes each file given as a PDF.
+func Export(opts *options.Options) {
+	overrideConfig(opts)
+
+	export := opts.Export
+	if !export.PDF {
+		sys.ErrorAndExit("Only PDF export (--pdf) is supported.")
+	}
+	if len(export.Files) == 0 {
+		sys.ErrorAndExit("No markdown given to export.")
+	}
+	if export.Output != "" && len(export.Files) > 1 {
+		sys.ErrorAndExit("-o cannot be used when exporting more than one markdown.")
+	}
+	size, ok := pageSizes[strings.ToLower(export.PageSize)]
+	if !ok {
+		sys.ErrorAndExit(fmt.Sprintf("Unknown page size %s. Use a4 or letter.", export.PageSize))
+	}
+
+	for _, file := range export.Files {
+		output := export.Output
+		if output == "" {
+			output = strings.TrimSuffix(file, path.Ext(file)) + ".pdf"
+		}
+
+		if err := exportFile(file, output, size); err != nil {
+			sys.ErrorAndExit(err.Error())
+		}
+		if output != "-" {
+			sys.Eprintf("Exported %s to %s\n", file, output)
+		}
+	}
+}
+
+// Exports the markdown at file as a PDF to output (or stdout if "-").
+//
+// The PDF is rendered before output is touched, and then replaces it
+// at once, so that a failed export never empties or removes it.
+func exportFile(file string, output string, size pdf.Size) error {
+	if output != "-" && sameFile(file, output) {
+		return fmt.Errorf("Exporting %s to %s would overwrite it. Use -o to write the PDF elsewhere.", file, output)
+	}
+
+	var rendered bytes.Buffer
+	if err := exportPDF(file, &rendered, size); err != nil {
+		return err
+	}
+	if output == "-" {
+		_, err := os.Stdout.Write(rendered.Bytes())
+		return err
+	}
+
+	return replaceFile(output, rendered.Bytes())
+}
+
+// Returns true if a and b are paths to the same file.
+func sameFile(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// Writes data to a temporary file next to name, which is then renamed
+// to name. Only the temporary file is removed on failure.
+func replaceFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed.
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Like os.Create (before the umask), instead of 0600.
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// Returns the text of the first heading, or fallback if there is none.
+func (e *pdfExporter) title(document ast.Node, fallback string) string {
+	for n := document.FirstChild(); n != nil; n = n.NextSibling() {
+		if heading, ok := n.(*ast.Heading); ok {
+			var title strings.Builder
+			for _, s := range e.inlineSpans(heading, inlineStyle{}) {
+				title.WriteString(s.text)
+			}
+			return title.String()
+		}
+	}
+
+	return fallback
+}
+
+func (e *pdfExporter) value(segments *text.Segments) []byte {
+	var value []byte
+	for i := 0; i < segments.Len(); i++ {
+		segment := segments.At(i)
+		value = append(value, segment.Value(e.source)...)
+	}
+
+	return bytes.TrimSpace(value)
+}
+
+func (e *pdfExporter) newPage() {
+	e.page = e.doc.AddPage()
+	e.y = pdf_margin
+}
+
+func (e *pdfExporter) bottom() float64 {
+	return e.doc.Size().Height - pdf_margin
+}
+
+// Reserves height on the page, moving to a new page if it does
+// not fit, and returns the top of the space reserved.
+func (e *pdfExporter) reserve(height float64) float64 {
+	if e.y+height > e.bottom() && e.y > pdf_margin {
+		e.newPage()
+	}
+
+	top := e.y
+	for _, x := range e.quoteBars {
+		e.page.Rect(x, top, 3, height, pdfBorderColor)
+	}
+	e.y += height
+	return top
+}
+
+// Leaves space between blocks, unless at the top of a page.
+func (e *pdfExporter) gap(height float64) {
+	if e.y > pdf_margin {
+		e.reserve(min(height, e.bottom()-e.y))
+	}
+}
+
+func (e *pdfExporter) writeBlocks(parent ast.Node, x, width float64) {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		e.writeBlock(n, x, width)
+	}
+}
+
+func (e *pdfExporter) writeBlock(n ast.Node, x, width float64) {
+	base := inlineStyle{size: pdf_font_size, color: e.color}
+
+	switch node := n.(type) {
+	case *ast.Heading:
+		size := pdfHeadingSizes[node.Level]
+		style := inlineStyle{bold: true, size: size, color: e.color}
+		if node.Level == 6 {
+			style.color = pdfMutedColor
+		}
+
+		// Keep the heading on the same page as (the start of) the
+		// block following it.
+		if e.y+size*pdf_line_height+3*pdf_font_size*pdf_line_height > e.bottom() {
+			e.newPage()
+		}
+		e.gap(size * 0.5)
+		e.writeInline(node, x, width, style)
+		if node.Level <= 2 {
+			top := e.reserve(6)
+			e.page.Rect(x, top+3, width, 1, pdfBorderColor)
+		}
+		e.gap(pdf_block_gap)
+
+	case *ast.Paragraph:
+		if latexPageBreakRegex.Match(e.value(node.Lines())) {
+			if e.y > pdf_margin {
+				e.newPage()
+			}
+			return
+		}
+		e.writeInline(node, x, width, base)
+		e.gap(pdf_block_gap)
+
+	case *ast.TextBlock:
+		e.writeInline(node, x, width, base)
+
+	case *ast.List:
+		e.writeList(node, x, width)
+		e.gap(pdf_block_gap)
+
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		e.writeCode(n, x, width)
+		e.gap(pdf_block_gap)
+
+	case *ast.Blockquote:
+		color := e.color
+		e.color = pdfMutedColor
+		e.quoteBars = append(e.quoteBars, x)
+		e.writeBlocks(node, x+14, width-14)
+		e.quoteBars = e.quoteBars[:len(e.quoteBars)-1]
+		e.color = color
+		e.gap(pdf_block_gap)
+
+	case *ast.ThematicBreak:
+		top := e.reserve(12)
+		e.page.Rect(x, top+5, width, 2, pdfBorderColor)
+		e.gap(pdf_block_gap)
+
+	case *ast.HTMLBlock:
+		// Raw HTML is not rendered, except for page breaks.
+		if pageBreakRegex.Match(e.value(node.Lines())) && e.y > pdf_margin {
+			e.newPage()
+		}
+
+	case *extast.Table:
+		e.writeTable(node, x, width)
+		e.gap(pdf_block_gap)
+
+	default:
+		// e.g. footnotes and definition lists.
+		e.writeBlocks(n, x, width)
+	}
+}
+
+func (e *pdfExporter) writeList(list *ast.List, x, width float64) {
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		switch {
+		case isTaskItem(item):
+			// The checkbox replaces the bullet.
+			e.marker = ""
+		case list.IsOrdered():
+			e.marker = fmt.Sprintf("%d.", number)
+			number++
+		default:
+			e.marker = "•"
+		}
+
+		e.writeBlocks(item, x+pdf_indent, width-pdf_indent)
+		e.marker = ""
+		if !list.IsTight && item.NextSibling() != nil {
+			e.gap(pdf_block_gap)
+		}
+	}
+}
+
+func isTaskItem(item ast.Node) bool {
+	if item.FirstChild() == nil || item.FirstChild().FirstChild() == nil {
+		return false
+	}
+	_, ok := item.FirstChild().FirstChild().(*extast.TaskCheckBox)
+	return ok
+}
+
+// Returns the text of the inline nodes under parent, split into spans.
+func (e *pdfExporter) inlineSpans(parent ast.Node, style inlineStyle) []span {
+	var spans []span
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		s := style
+		switch node := n.(type) {
+		case *ast.Text:
+			spans = append(spans, span{inlineStyle: s, text: string(node.Segment.Value(e.source))})
+			if node.HardLineBreak() {
+				spans = append(spans, span{inlineStyle: s, text: "\n"})
+			} else if node.SoftLineBreak() {
+				spans = append(spans, span{inlineStyle: s, text: " "})
+			}
+
+		case *ast.String:
+			spans = append(spans, span{inlineStyle: s, text: string(node.Value)})
+
+		case *ast.CodeSpan:
+			s.code = true
+			s.size *= 0.9
+			spans = append(spans, e.inlineSpans(node, s)...)
+
+		case *ast.Emphasis:
+			if node.Level >= 2 {
+				s.bold = true
+			} else {
+				s.italic = true
+			}
+			spans = append(spans, e.inlineSpans(node, s)...)
+
+		case *ast.Link:
+			s.link = string(node.Destination)
+			s.color = pdfLinkColor
+			spans = append(spans, e.inlineSpans(node, s)...)
+
+		case *ast.AutoLink:
+			s.link = string(node.URL(e.source))
+			s.color = pdfLinkColor
+			spans = append(spans, span{inlineStyle: s, text: string(node.Label(e.source))})
+
+		case *ast.Image:
+			spans = append(spans, span{inlineStyle: s, image: node})
+
+		case *ast.RawHTML:
+			if lineBreakRegex.Match(e.value(node.Segments)) {
+				spans = append(spans, span{inlineStyle: s, text: "\n"})
+			}
+
+		case *extast.Strikethrough:
+			s.strike = true
+			spans = append(spans, e.inlineSpans(node, s)...)
+
+		case *extast.TaskCheckBox:
+			s.code = true
+			checkbox := "[ ] "
+			if node.IsChecked {
+				checkbox = "[x] "
+			}
+			spans = append(spans, span{inlineStyle: s, text: checkbox})
+
+		case *extast.FootnoteLink:
+			s.color = pdfLinkColor
+			spans = append(spans, span{inlineStyle: s, text: fmt.Sprintf("[%d]", node.Index)})
+
+		case *emojiast.Emoji:
+			// Emojis are not in the standard fonts.
+			spans = append(spans, span{inlineStyle: s, text: ":" + string(node.ShortName) + ":"})
+
+		default:
+			spans = append(spans, e.inlineSpans(n, s)...)
+		}
+	}
+
+	return spans
+}
+
+// Splits spans into lines no wider than width. Spans of the same
+// style on a line are merged.
+func wrapSpans(spans []span, width float64) [][]piece {
+	var lines [][]piece
+	var line []piece
+	x := 0.0
+
+	newLine := func() {
+		// Trailing spaces are not drawn.
+		if len(line) > 0 {
+			last := &line[len(line)-1]
+			trimmed := strings.TrimRight(last.text, " ")
+			last.width -= pdf.TextWidth(last.font(), last.size, last.text[len(trimmed):])
+			last.text = trimmed
+		}
+		lines = append(lines, line)
+		line = nil
+		x = 0
+	}
+	add := func(s span, word string, w float64) {
+		if len(line) > 0 && line[len(line)-1].inlineStyle == s.inlineStyle {
+			line[len(line)-1].text += word
+			line[len(line)-1].width += w
+		} else {
+			line = append(line, piece{span: span{inlineStyle: s.inlineStyle, text: word}, x: x, width: w})
+		}
+		x += w
+	}
+
+	for _, s := range spans {
+		if s.image != nil {
+			continue
+		}
+
+		for _, word := range splitWords(s.text) {
+			if word == "\n" {
+				newLine()
+				continue
+			}
+
+			font := s.font()
+			// With some leeway for rounding, so that a table cell
+			// as wide as its contents is not wrapped.
+			if x > 0 && x+pdf.TextWidth(font, s.size, strings.TrimRight(word, " ")) > width+0.01 {
+				newLine()
+			}
+			if x == 0 {
+				word = strings.TrimLeft(word, " ")
+			}
+
+			// Words longer than a line are broken anywhere.
+			for word != "" && pdf.TextWidth(font, s.size, strings.TrimRight(word, " ")) > width-x+0.01 {
+				n := 1
+				for n < len(word) && pdf.TextWidth(font, s.size, word[:n+1]) <= width-x {
+					n++
+				}
+				for n < len(word) && !utf8.RuneStart(word[n]) {
+					n++
+				}
+				add(s, word[:n], pdf.TextWidth(font, s.size, word[:n]))
+				newLine()
+				word = word[n:]
+			}
+			if word != "" {
+				add(s, word, pdf.TextWidth(font, s.size, word))
+			}
+		}
+	}
+	if len(line) > 0 {
+		newLine()
+	}
+
+	return lines
+}
+
+// Splits text into words, each with the spaces following it.
+// Line breaks are words of their own.
+func splitWords(text string) []string {
+	var words []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\n':
+			if start < i {
+				words = append(words, text[start:i])
+			}
+			words = append(words, "\n")
+			start = i + 1
+		case text[i] == ' ' && (i+1 == len(text) || text[i+1] != ' '):
+			words = append(words, text[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+
+	return words
+}
+
+// Writes the inline content of parent, with images as blocks.
+func (e *pdfExporter) writeInline(parent ast.Node, x, width float64, style inlineStyle) {
+	var spans []span
+	for _, s := range e.inlineSpans(parent, style) {
+		if s.image != nil {
+			e.writeLines(wrapSpans(spans, width), x, style.size)
+			spans = nil
+			e.writeImage(s.image, x, width)
+			continue
+		}
+		spans = append(spans, s)
+	}
+
+	e.writeLines(wrapSpans(spans, width), x, style.size)
+}
+
+func (e *pdfExporter) writeLines(lines [][]piece, x float64, size float64) {
+	for _, line := range lines {
+		height := size * pdf_line_height
+		baseline := e.reserve(height) + height/2 + size*0.35
+
+		if e.marker != "" {
+			markerWidth := pdf.TextWidth(pdf.Helvetica, size, e.marker)
+			e.page.Text(x-6-markerWidth, baseline, pdf.Helvetica, size, e.color, e.marker)
+			e.marker = ""
+		}
+		for _, p := range line {
+			e.writePiece(p, x, baseline)
+		}
+	}
+}
+
+func (e *pdfExporter) writePiece(p piece, x, baseline float64) {
+	x += p.x
+	if p.code {
+		e.page.Rect(x-1, baseline-p.size*0.85, p.width+2, p.size*1.2, pdfCodeColor)
+	}
+	e.page.Text(x, baseline, p.font(), p.size, p.color, p.text)
+	if p.strike {
+		e.page.Line(x, baseline-p.size*0.3, x+p.width, baseline-p.size*0.3, 0.6, p.color)
+	}
+	// Links within the document are not kept.
+	if p.link != "" && !strings.HasPrefix(p.link, "#") {
+		e.page.Link(x, baseline-p.size, p.width, p.size*1.25, p.link)
+	}
+}
+
+// Writes an image scaled down to fit the width (and the page).
+// Images that cannot be read or embedded (e.g. SVGs) are replaced
+// with their alt text.
+func (e *pdfExporter) writeImage(node *ast.Image, x, width float64) {
+	img, err := e.loadImage(string(node.Destination))
+	if err != nil {
+		style := inlineStyle{italic: true, size: pdf_font_size, color: pdfMutedColor}
+		alt := append(append([]span{{inlineStyle: style, text: "["}}, e.inlineSpans(node, style)...), span{inlineStyle: style, text: "]"})
+		e.writeLines(wrapSpans(alt, width), x, pdf_font_size)
+		return
+	}
+
+	// From CSS pixels to points.
+	w, h := float64(img.Width)*0.75, float64(img.Height)*0.75
+	if w > width {
+		w, h = width, h*width/w
+	}
+	if maxHeight := e.doc.Size().Height - 2*pdf_margin; h > maxHeight {
+		w, h = w*maxHeight/h, maxHeight
+	}
+
+	top := e.reserve(h)
+	e.page.Image(img, x, top, w, h)
+}
+
+// Reads an image like the browser would: remote images are fetched,
+// others are read relative to the markdown (or the current directory
+// if the path is absolute).
+func (e *pdfExporter) loadImage(src string) (*pdf.Image, error) {
+	if img, ok := e.images[src]; ok {
+		return img, nil
+	}
+
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned %s", src, resp.Status)
+		}
+		data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+	case u.Scheme == "" && u.Host == "":
+		p := path.Join(e.dir, u.Path)
+		if strings.HasPrefix(u.Path, "/") {
+			p = path.Clean(u.Path[1:])
+		}
+		data, err = readSource(p)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.New("Unsupported image source " + src)
+	}
+
+	img, err := e.doc.AddImage(data)
+	if err != nil {
+		return nil, err
+	}
+	e.images[src] = img
+	return img, nil
+}
+
+type codeToken struct {
+	text  string
+	color pdf.Color
+	bold  bool
+}
+
+// Writes a code block highlighted with the colors of the configured
+// code block style. Lines too long for the page are wrapped.
+func (e *pdfExporter) writeCode(n ast.Node, x, width float64) {
+	var code strings.Builder
+	for i := 0; i < n.Lines().Len(); i++ {
+		segment := n.Lines().At(i)
+		code.Write(segment.Value(e.source))
+	}
+
+	var lexer chroma.Lexer
+	if fenced, ok := n.(*ast.FencedCodeBlock); ok {
+		lexer = lexers.Get(string(fenced.Language(e.source)))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	size := pdf_font_size * 0.85
+	height := size * 1.45
+	charWidth := pdf.TextWidth(pdf.Courier, size, " ")
+	maxChars := max(int((width-2*pdf_code_pad)/charWidth), 1)
+
+	background := pdfCodeColor
+	if bg := e.style.Get(chroma.Background).Background; bg.IsSet() {
+		background = pdf.Color{R: bg.Red(), G: bg.Green(), B: bg.Blue()}
+	}
+	textColor := pdfTextColor
+	if fg := e.style.Get(chroma.Text).Colour; fg.IsSet() {
+		textColor = pdf.Color{R: fg.Red(), G: fg.Green(), B: fg.Blue()}
+	}
+
+	var lines [][]codeToken
+	var line []codeToken
+	column := 0
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		iterator = chroma.Literator(chroma.Token{Type: chroma.Text, Value: code.String()})
+	}
+	for _, token := range iterator.Tokens() {
+		entry := e.style.Get(token.Type)
+		color := textColor
+		if entry.Colour.IsSet() {
+			color = pdf.Color{R: entry.Colour.Red(), G: entry.Colour.Green(), B: entry.Colour.Blue()}
+		}
+
+		value := strings.ReplaceAll(strings.ReplaceAll(token.Value, "\t", "    "), "\r", "")
+		for i, part := range strings.Split(value, "\n") {
+			if i > 0 {
+				lines = append(lines, line)
+				line = nil
+				column = 0
+			}
+			for part != "" {
+				runes := []rune(part)
+				n := min(len(runes), maxChars-column)
+				line = append(line, codeToken{string(runes[:n]), color, entry.Bold == chroma.Yes})
+				column += n
+				part = string(runes[n:])
+				if part != "" {
+					lines = append(lines, line)
+					line = nil
+					column = 0
+				}
+			}
+		}
+	}
+	// The code ends with a line break, unless it is empty.
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+
+	top := e.reserve(pdf_code_pad)
+	e.page.Rect(x, top, width, pdf_code_pad, background)
+	for _, line := range lines {
+		top := e.reserve(height)
+		e.page.Rect(x, top, width, height, background)
+
+		tx := x + pdf_code_pad
+		for _, token := range line {
+			font := pdf.Courier
+			if token.bold {
+				font = pdf.CourierBold
+			}
+			e.page.Text(tx, top+height*0.72, font, size, token.color, token.text)
+			tx += float64(utf8.RuneCountInString(token.text)) * charWidth
+		}
+	}
+	top = e.reserve(pdf_code_pad)
+	e.page.Rect(x, top, width, pdf_code_pad, background)
+}
+
+// Writes a table whose columns are as wide as their contents,
+// narrowed in proportion if the table does not fit the width.
+func (e *pdfExporter) writeTable(table *extast.Table, x, width float64) {
+	columns := len(table.Alignments)
+	if columns == 0 {
+		return
+	}
+
+	var rows [][][]span
+	hasHeader := false
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		style := inlineStyle{size: pdf_font_size, color: e.color}
+		if _, ok := row.(*extast.TableHeader); ok {
+			style.bold = true
+			hasHeader = true
+		}
+
+		var cells [][]span
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, e.inlineSpans(cell, style))
+		}
+		rows = append(rows, cells)
+	}
+
+	widths := make([]float64, columns)
+	total := 0.0
+	for i := range widths {
+		for _, cells := range rows {
+			if i < len(cells) {
+				for _, line := range wrapSpans(cells[i], 1e9) {
+					if len(line) > 0 {
+						last := line[len(line)-1]
+						widths[i] = max(widths[i], last.x+last.width)
+					}
+				}
+			}
+		}
+		widths[i] += 2 * pdf_cell_pad
+		total += widths[i]
+	}
+	if total > width {
+		for i := range widths {
+			widths[i] *= width / total
+		}
+	}
+
+	height := pdf_font_size * pdf_line_height
+	for r, cells := range rows {
+		wrapped := make([][][]piece, columns)
+		lines := 1
+		for i := 0; i < columns && i < len(cells); i++ {
+			wrapped[i] = wrapSpans(cells[i], widths[i]-2*pdf_cell_pad)
+			lines = max(lines, len(wrapped[i]))
+		}
+
+		rowHeight := float64(lines)*height + 2*pdf_cell_pad
+		top := e.reserve(rowHeight)
+
+		cx := x
+		for i := 0; i < columns; i++ {
+			if r == 0 && hasHeader {
+				e.page.Rect(cx, top, widths[i], rowHeight, pdfCodeColor)
+			}
+			e.page.Line(cx, top, cx+widths[i], top, 0.75, pdfBorderColor)
+			e.page.Line(cx, top+rowHeight, cx+widths[i], top+rowHeight, 0.75, pdfBorderColor)
+			e.page.Line(cx, top, cx, top+rowHeight, 0.75, pdfBorderColor)
+			e.page.Line(cx+widths[i], top, cx+widths[i], top+rowHeight, 0.75, pdfBorderColor)
+
+			for j, line := range wrapped[i] {
+				offset := 0.0
+				if len(line) > 0 {
+					free := widths[i] - 2*pdf_cell_pad - (line[len(line)-1].x + line[len(line)-1].width)
+					switch table.Alignments[i] {
+					case extast.AlignRight:
+						offset = free
+					case extast.AlignCenter:
+						offset = free / 2
+					}
+				}
+
+				baseline := top + pdf_cell_pad + float64(j)*height + height/2 + pdf_font_size*0.35
+				for _, p := range line {
+					e.writePiece(p, cx+pdf_cell_pad+offset, baseline)
+				}
+			}
+			cx += widths[i]
+		}
+	}
+}
+
+// Numbers each page ("n / total") at its bottom.
+func (e *pdfExporter) writePageNumbers() {
+	pages := e.doc.Pages()
+	size := e.doc.Size()
+	for i, page := range pages {
+		number := fmt.Sprintf("%d / %d", i+1, len(pages))
+		width := pdf.TextWidth(pdf.Helvetica, 9, number)
+		page.Text((size.Width-width)/2, size.Height-pdf_margin/2, pdf.Helvetica, 9, pdfMutedColor, number)
+	}
+}
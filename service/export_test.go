@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"spamd/internal/pdf"
+)
+
+func TestExportPDF(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.md")
+	file.WriteString("# Spec\n\nSome **text**.\n\n\\newpage\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n```go\nfunc main() {}\n```\n")
+	defer os.Remove(file.Name())
+
+	var out bytes.Buffer
+	if err := exportPDF(file.Name(), &out, pdf.A4); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	for _, want := range []string{"%PDF-1.4", "/Title (Spec)", "/Count 2", "%%EOF"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got %s; want to contain %s", out.String(), want)
+		}
+	}
+}
+
+func TestExportNonMarkdownFails(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.go")
+	defer os.Remove(file.Name())
+
+	if err := exportPDF(file.Name(), &bytes.Buffer{}, pdf.A4); err == nil {
+		t.Error("got <nil>; want error on non-markdown file")
+	}
+}
+
+func TestExportFileKeepsOutputOnError(t *testing.T) {
+	output, _ := os.CreateTemp(".", "*.pdf")
+	output.WriteString("existing report")
+	output.Close()
+	defer os.Remove(output.Name())
+
+	// report.pdf is given as the markdown (exported to itself).
+	if err := exportFile(output.Name(), output.Name(), pdf.A4); err == nil {
+		t.Error("got <nil>; want error on non-markdown file")
+	}
+	source, _ := os.CreateTemp(".", "*.go")
+	defer os.Remove(source.Name())
+	if err := exportFile(source.Name(), output.Name(), pdf.A4); err == nil {
+		t.Error("got <nil>; want error on non-markdown file")
+	}
+
+	if data, err := os.ReadFile(output.Name()); err != nil || string(data) != "existing report" {
+		t.Errorf("got %q (%v); want the output left as is", data, err)
+	}
+}
+
+func TestExportFileRefusesInputAsOutput(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.md")
+	file.WriteString("# Spec\n")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := exportFile(file.Name(), "./"+file.Name()[2:], pdf.A4); err == nil {
+		t.Error("got <nil>; want error when exporting a markdown to itself")
+	}
+	if data, _ := os.ReadFile(file.Name()); string(data) != "# Spec\n" {
+		t.Errorf("got %q; want the markdown left as is", data)
+	}
+}
+
+func TestExportFileReplacesOutput(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.md")
+	file.WriteString("# Spec\n")
+	file.Close()
+	defer os.Remove(file.Name())
+	output := strings.TrimSuffix(file.Name(), ".md") + ".pdf"
+	os.WriteFile(output, []byte("old"), 0644)
+	defer os.Remove(output)
+
+	if err := exportFile(file.Name(), output, pdf.A4); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if data, _ := os.ReadFile(output); !strings.HasPrefix(string(data), "%PDF-1.4") {
+		t.Errorf("got %.20q; want a PDF", data)
+	}
+}
+
+func TestWrapSpans(t *testing.T) {
+	style := inlineStyle{size: 10}
+	spans := []span{
+		{inlineStyle: style, text: "aaaa bbbb "},
+		{inlineStyle: inlineStyle{size: 10, bold: true}, text: "cccc"},
+		{inlineStyle: style, text: "\ndddddddddddddddddddd"},
+	}
+	width := pdf.TextWidth(pdf.Helvetica, 10, "aaaa bbbb")
+
+	var got []string
+	for _, line := range wrapSpans(spans, width) {
+		var text []string
+		for _, p := range line {
+			text = append(text, p.text)
+		}
+		got = append(got, strings.Join(text, "|"))
+	}
+
+	// Words longer than a line are broken anywhere.
+	want := []string{"aaaa bbbb", "cccc", "dddddddd", "dddddddd", "dddd"}
+	if strings.Join(got, "/") != strings.Join(want, "/") {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A markup format rendered into a document, as opposed to other
+// text files, which are displayed as code.
+type markupFormat struct {
+	name string
+	// Returns true if filepath is a document in this format.
+	match func(filepath string) bool
+	// Converts the source of a document into HTML. The result may be
+	// cached under key (the path of the document, or "<ref>:<path>"
+	// for the old side of a diff).
+	convert func(key string, source []byte) ([]byte, error)
+}
+
+// Formats checked (in order) to render a file. Add an entry here
+// to preview another markup format.
+var markupFormats = []markupFormat{
+	{"markdown", isMarkdown, convertMarkdown},
+	{"asciidoc", hasExt(".adoc", ".asciidoc", ".asc"), convertAsciiDoc},
+	{"notebook", hasExt(".ipynb"), convertNotebook},
+	{"csv", hasExt(".csv"), convertDelimited(',')},
+	{"tsv", hasExt(".tsv"), convertDelimited('\t')},
+}
+
+// Returns a matcher for files with one of exts (case-insensitive).
+func hasExt(exts ...string) func(filepath string) bool {
+	return func(filepath string) bool {
+		ext := path.Ext(filepath)
+		for _, e := range exts {
+			if strings.EqualFold(ext, e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Returns the format of the document at filepath, or nil
+// if it is not in any of markupFormats.
+func formatOf(filepath string) *markupFormat {
+	for i := range markupFormats {
+		if markupFormats[i].match(filepath) {
+			return &markupFormats[i]
+		}
+	}
+
+	return nil
+}
+
+// Returns true if filepath is a document in any of markupFormats.
+func isMarkup(filepath string) bool {
+	return formatOf(filepath) != nil
+}
+
+// Renders the file at filepath into HTML: documents are converted
+// with their format, any other text file is shown as highlighted code.
+func renderFile(filepath string) ([]byte, error) {
+	format := formatOf(filepath)
+	if format == nil {
+		return convertCodeToHTML(filepath)
+	}
+
+	source, err := cache.ReadSource(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", filepath, err)
+	}
+
+	return format.convert(filepath, source)
+}
+
+// Converts source with convert, caching the result under key until
+// source or the render options change. The result is sanitized in
+// safe mode.
+func convertCached(key string, source []byte, convert func(source []byte) ([]byte, error)) ([]byte, error) {
+	return cache.Convert(key, source, func(source []byte) ([]byte, error) {
+		result, err := convert(source)
+		if err != nil && !errors.Is(err, errNotCached) {
+			return nil, err
+		}
+		if serviceConfig.Safe {
+			result = sanitize(result)
+		}
+		return result, err
+	})
+}
@@ -0,0 +1,501 @@
+// Frontend of every page served by spamd.
+//
+// This is served as a separate (embedded) file, so that the page can be
+// protected with a strict Content-Security-Policy. Values set by the
+// server are read from the data attributes of <body>.
+
+// Set the name of the hidden property and the change event for visibility
+var hidden, visibilityChange;
+if (typeof document.hidden !== "undefined") {
+  // Opera 12.10 and Firefox 18 and later support
+  hidden = "hidden";
+  visibilityChange = "visibilitychange";
+} else if (typeof document.msHidden !== "undefined") {
+  hidden = "msHidden";
+  visibilityChange = "msvisibilitychange";
+} else if (typeof document.webkitHidden !== "undefined") {
+  hidden = "webkitHidden";
+  visibilityChange = "webkitvisibilitychange";
+}
+
+if (
+  typeof document.addEventListener === "undefined" ||
+  hidden === undefined
+) {
+  alert(
+    "This tool requires a browser, such as Google Chrome or Firefox, that supports the Page Visibility API."
+  );
+}
+
+// Path the server is mounted at behind a reverse proxy ("" at the root).
+const basePath = document.body.dataset.basePath || "";
+
+// This connection is used to receive new markdown content from
+// server whenever a file is modified.
+let stream;
+function Stream(handlers) {
+  this.ws = new WebSocket(
+    (location.protocol === "https:" ? "wss://" : "ws://") +
+      location.host +
+      document.body.dataset.refreshPrefix +
+      document.body.dataset.uri +
+      document.body.dataset.query
+  );
+  Object.keys(handlers).forEach((name) => {
+    this.ws[name] = handlers[name];
+  });
+  this.close = () => {
+    this.ws.close();
+    this.ws.removeEventListener("message", this.ws.onmessage);
+    this.ws.removeEventListener("error", this.ws.onerror);
+  };
+}
+
+let hasLoadedContent = false;
+function setupNewStream() {
+  if (!hasLoadedContent) {
+    hasLoadedContent = true;
+    stream = new Stream({
+      onmessage: refreshContent,
+      onerror: cleanup,
+      onclose: cleanup,
+    });
+  }
+}
+
+// Create connection only once visited.
+//
+// Otherwise, if the tab has not been visited, do not
+// call `refresh` API. Only static assets are loaded first.
+window.addEventListener(visibilityChange, () => setupNewStream());
+if (document.visibilityState === "visible") {
+  setupNewStream();
+}
+
+function addCopyCodeButtons() {
+  document.querySelectorAll("code").forEach((codeBlock) => {
+    // <pre> surrounding each code element.
+    const preWrapperElem = codeBlock.parentElement;
+    if (preWrapperElem.tagName !== "PRE" || codeBlock.closest(".code-file")) {
+      return;
+    }
+
+    preWrapperElem.classList.add("code-block");
+    preWrapperElem.style.cssText = "position: relative;";
+
+    const newCopyButton = document.createElement("button");
+    newCopyButton.classList.add("copy-code");
+
+    const copySvg = document.createElementNS("http://www.w3.org/2000/svg", "svg");
+    const copySvgPath1 = document.createElementNS("http://www.w3.org/2000/svg", "path");
+    const copySvgPath2 = document.createElementNS("http://www.w3.org/2000/svg", "path");
+    copySvg.setAttribute("aria-hidden", "true");
+    copySvg.setAttribute("height", "16");
+    copySvg.setAttribute("width", "16");
+    copySvg.setAttribute("viewBox", "0 0 16 16");
+    copySvg.setAttribute("version", "1.1");
+    copySvg.setAttribute("data-view-components", "true");
+    copySvg.style["display"] = "block";
+    copySvg.style["position"] = "relative";
+    copySvg.style["left"] = "1px";
+    copySvg.style["pointer-events"] = "none";
+    copySvg.style["color"] = "#24292f";
+    copySvgPath1.setAttribute("fill-rule", "evenodd");
+    copySvgPath1.setAttribute("d", "M0 6.75C0 5.784.784 5 1.75 5h1.5a.75.75 0 010 1.5h-1.5a.25.25 0 00-.25.25v7.5c0 .138.112.25.25.25h7.5a.25.25 0 00.25-.25v-1.5a.75.75 0 011.5 0v1.5A1.75 1.75 0 019.25 16h-7.5A1.75 1.75 0 010 14.25v-7.5z");
+    copySvg.appendChild(copySvgPath1);
+    copySvgPath2.setAttribute("fill-rule", "evenodd");
+    copySvgPath2.setAttribute("d", "M5 1.75C5 .784 5.784 0 6.75 0h7.5C15.216 0 16 .784 16 1.75v7.5A1.75 1.75 0 0114.25 11h-7.5A1.75 1.75 0 015 9.25v-7.5zm1.75-.25a.25.25 0 00-.25.25v7.5c0 .138.112.25.25.25h7.5a.25.25 0 00.25-.25v-7.5a.25.25 0 00-.25-.25h-7.5z");
+    copySvg.appendChild(copySvgPath2);
+
+    const greenTick = document.createElementNS("http://www.w3.org/2000/svg", "svg");
+    greenTick.setAttribute("fill", "none");
+    greenTick.setAttribute("stroke", "currentColor");
+    greenTick.setAttribute("viewBox", "0 0 24 24");
+    greenTick.style["display"] = "none";
+    greenTick.style["position"] = "relative";
+    greenTick.style["color"] = "#2da44e";
+    const greenTickPath = document.createElementNS("http://www.w3.org/2000/svg", "path");
+    greenTickPath.setAttribute("stroke-linecap", "round");
+    greenTickPath.setAttribute("stroke-linejoin", "round");
+    greenTickPath.setAttribute("stroke-width", "2");
+    greenTickPath.setAttribute("d", "M5 13l4 4L19 7");
+    greenTick.appendChild(greenTickPath);
+
+    // Add green tick first.
+    newCopyButton.prepend(greenTick);
+    newCopyButton.prepend(copySvg);
+
+    globalThis.clicked = false;
+    newCopyButton.addEventListener("click", function(e) {
+      if (globalThis.clicked === true) {
+        return;
+      }
+      globalThis.clicked = true;
+      const parentElem = e.target.parentElement;
+      if (parentElem) {
+        navigator.clipboard.writeText(parentElem.textContent);
+      }
+      // Display green tick box.
+      newCopyButton.style["border"] = "1px solid #2da44e";
+      // Hide double squares, and make tick visible.
+      newCopyButton.children[0].style["display"] = "none";
+      newCopyButton.children[1].style["display"] = "block";
+      // Display thumbnail beside button.
+      createThumbnail(preWrapperElem, -preWrapperElem.scrollLeft + 45);
+    });
+
+    preWrapperElem.prepend(newCopyButton);
+    // Remove copy button during scrolling, but restore later.
+    let timer = null;
+    preWrapperElem.addEventListener("scroll", function(e) {
+      try {
+        if (preWrapperElem.children.length >= 3) {
+          preWrapperElem.removeChild(preWrapperElem.children[0]);
+          preWrapperElem.removeChild(preWrapperElem.children[0]);
+          preWrapperElem.removeChild(preWrapperElem.children[0]);
+        } else {
+          preWrapperElem.removeChild(newCopyButton);
+        }
+        // Remove thumbnail if any.
+        if(timer !== null) {
+          clearTimeout(timer);
+        }
+        timer = setTimeout(function() {
+          // Put the button at newly calculated position.
+          if (e.target.children.length > 0) {
+            newCopyButton.style["right"] = `${-e.target.scrollLeft + 8}px`;
+          }
+          preWrapperElem.prepend(newCopyButton);
+        }, 150);
+      } catch(err) {
+        // Ignore from trying to remove non-existent element.
+      }
+    });
+  });
+}
+
+// @rightPos: position of thumbnail from the right of parent.
+function createThumbnail(parentElem, rightPos) {
+  const newCopyButton = parentElem.children[0];
+
+  // Thumbnail.
+  const thumbnail = document.createElement("div");
+  thumbnail.innerHTML = "Copied!";
+  thumbnail.classList.add("code-thumbnail");
+  if (rightPos) {
+    thumbnail.style["right"] = `${rightPos}px`;
+  }
+  parentElem.prepend(thumbnail);
+  // Right arrow.
+  const thumbnailArrow = document.createElement("div");
+  thumbnailArrow.classList.add("code-thumbnail-arrow");
+  if (rightPos) {
+    thumbnailArrow.style["right"] = `${rightPos-5}px`;
+  }
+  parentElem.prepend(thumbnailArrow);
+
+  if (newCopyButton) {
+    // Return back to normal button after short period.
+    setTimeout(() => {
+      newCopyButton.style["border"] = "1px solid rgba(27,31,36,0.15)";
+      newCopyButton.children[0].style["display"] = "block";
+      newCopyButton.children[1].style["display"] = "none";
+      try {
+        // Thumbnail could be removed during scroll event.
+        parentElem.removeChild(thumbnail);
+        parentElem.removeChild(thumbnailArrow);
+        globalThis.clicked = false;
+      } catch(err) {}
+    }, 500);
+  }
+}
+
+function removeBulletPointsFromTaskListItem() {
+  // Remove bullet points from task list items.
+  // Each task item will have a checkbox type attribute.
+  const checkboxes = document.querySelectorAll('input[type="checkbox"]');
+  checkboxes.forEach((checkbox) => {
+    if (checkbox.classList.length === 0) {
+      checkbox.parentNode.style =
+        // Also shift left. Caused by <ul> padding css.
+        "list-style-type: none; margin-left: -25px;";
+    }
+  });
+}
+
+function setAttributeAllNodes(selector, name, val) {
+  const nodes = document.querySelectorAll(selector);
+  nodes.forEach((node) => node.setAttribute(name, val));
+}
+
+// Cache-busting versions of local images that were modified on disk,
+// keyed by their path on the server. These are kept across refreshes,
+// otherwise the browser shows the stale (cached) image again.
+const imageVersions = {};
+function applyImageVersions() {
+  document.querySelectorAll(".markdown-body img").forEach((img) => {
+    const url = new URL(img.getAttribute("src"), location.href);
+    if (url.origin !== location.origin) {
+      return;
+    }
+    let pathname = decodeURIComponent(url.pathname);
+    if (basePath && pathname.startsWith(basePath + "/")) {
+      pathname = pathname.slice(basePath.length);
+    }
+    const version = imageVersions[pathname];
+    if (version && url.searchParams.get("v") !== version) {
+      url.searchParams.set("v", version);
+      img.setAttribute("src", url.pathname + url.search);
+    }
+  });
+}
+
+// Root-relative links and images (e.g. /docs/a.png) point at the root
+// of the server, which is at the base path behind a reverse proxy.
+function prefixRootPaths() {
+  if (!basePath) {
+    return;
+  }
+  [
+    ["img", "src"],
+    ["a", "href"],
+    ["video", "src"],
+    ["source", "src"],
+  ].forEach(([tag, attr]) => {
+    document
+      .querySelectorAll(`.markdown-body ${tag}[${attr}^="/"]`)
+      .forEach((node) => {
+        const value = node.getAttribute(attr);
+        if (!value.startsWith("//") && !value.startsWith(basePath + "/")) {
+          node.setAttribute(attr, basePath + value);
+        }
+      });
+  });
+}
+
+function reloadImages(images) {
+  const version = String(Date.now());
+  images.forEach((image) => (imageVersions[image] = version));
+  applyImageVersions();
+}
+
+// Highlights the lines of a code file targeted by the URL anchor,
+// either a single line (#L10) or a range (#L10-L20), like on Github.
+let hasScrolledToLines = false;
+function highlightLines() {
+  document
+    .querySelectorAll(".code-file .hl-line")
+    .forEach((line) => line.classList.remove("hl-line"));
+
+  const match = location.hash.match(/^#L(\d+)(?:-L(\d+))?$/);
+  if (!match) {
+    return;
+  }
+  let from = parseInt(match[1]);
+  let to = match[2] ? parseInt(match[2]) : from;
+  if (from > to) {
+    [from, to] = [to, from];
+  }
+
+  for (let n = from; n <= to; n++) {
+    const lineNumber = document.getElementById("L" + n);
+    if (lineNumber) {
+      lineNumber.parentElement.classList.add("hl-line");
+    }
+  }
+
+  // The content arrives after the page has loaded, so the browser
+  // does not scroll to the anchor by itself.
+  const first = document.getElementById("L" + from);
+  if (first && !hasScrolledToLines) {
+    hasScrolledToLines = true;
+    first.scrollIntoView({ block: "center" });
+  }
+}
+
+// Shift-click on a line number selects the range from
+// the currently selected line.
+document.addEventListener("click", (event) => {
+  const link = event.target.closest(".code-file a[href^='#L']");
+  if (!link || !event.shiftKey) {
+    return;
+  }
+  const current = location.hash.match(/^#L(\d+)/);
+  if (current) {
+    event.preventDefault();
+    const from = parseInt(current[1]);
+    const to = parseInt(link.getAttribute("href").slice(2));
+    history.replaceState(null, "", `#L${Math.min(from, to)}-L${Math.max(from, to)}`);
+    highlightLines();
+  }
+});
+window.addEventListener("hashchange", highlightLines);
+
+// Sorting (by clicking a header) and filtering of CSV/TSV tables.
+// The view is kept across refreshes, so that editing the file does
+// not reset it.
+const tableView = { filter: "", column: -1, descending: false };
+function setupTables() {
+  document.querySelectorAll(".markdown-body .csv").forEach((csv) => {
+    const table = csv.querySelector("table");
+    if (!table) {
+      return;
+    }
+
+    const filter = document.createElement("input");
+    filter.type = "search";
+    filter.classList.add("csv-filter");
+    filter.placeholder = "Search this file...";
+    filter.value = tableView.filter;
+    filter.addEventListener("input", () => {
+      tableView.filter = filter.value;
+      applyTableView(table);
+    });
+    csv.insertBefore(filter, table);
+
+    table.querySelectorAll("thead th").forEach((th, column) => {
+      th.classList.add("csv-sortable");
+      th.addEventListener("click", () => {
+        if (tableView.column === column) {
+          tableView.descending = !tableView.descending;
+        } else {
+          tableView.column = column;
+          tableView.descending = false;
+        }
+        applyTableView(table);
+      });
+    });
+
+    applyTableView(table);
+  });
+}
+
+function compareCells(a, b) {
+  const x = Number(a);
+  const y = Number(b);
+  if (a !== "" && b !== "" && !isNaN(x) && !isNaN(y)) {
+    return x - y;
+  }
+  return a.localeCompare(b, undefined, { numeric: true, sensitivity: "base" });
+}
+
+function applyTableView(table) {
+  const tbody = table.tBodies[0];
+  if (!tbody) {
+    return;
+  }
+  const rows = Array.from(tbody.rows);
+
+  const query = tableView.filter.toLowerCase();
+  rows.forEach((row) => {
+    row.hidden = query !== "" && !row.textContent.toLowerCase().includes(query);
+  });
+
+  table.querySelectorAll("thead th").forEach((th, column) => {
+    th.dataset.sort =
+      column === tableView.column ? (tableView.descending ? "desc" : "asc") : "";
+  });
+  if (tableView.column < 0) {
+    return;
+  }
+
+  const cellText = (row) => {
+    const cell = row.cells[tableView.column];
+    return cell ? cell.textContent : "";
+  };
+  rows.sort((a, b) => {
+    const order = compareCells(cellText(a), cellText(b));
+    return tableView.descending ? -order : order;
+  });
+  rows.forEach((row) => tbody.appendChild(row));
+}
+
+// Shows why the file could not be read or rendered over the last page,
+// until the next page arrives or it is dismissed.
+function showRenderError(file, error) {
+  hideRenderError();
+
+  const overlay = document.createElement("div");
+  overlay.className = "render-error";
+  overlay.setAttribute("role", "alert");
+
+  const heading = document.createElement("div");
+  heading.className = "render-error-heading";
+  heading.textContent = "Failed to render " + file;
+
+  const dismiss = document.createElement("button");
+  dismiss.type = "button";
+  dismiss.className = "render-error-dismiss";
+  dismiss.setAttribute("aria-label", "Dismiss");
+  dismiss.textContent = "\u00d7";
+  dismiss.addEventListener("click", hideRenderError);
+  heading.appendChild(dismiss);
+
+  const details = document.createElement("pre");
+  details.textContent = error;
+
+  overlay.appendChild(heading);
+  overlay.appendChild(details);
+  document.body.appendChild(overlay);
+}
+
+function hideRenderError() {
+  const overlay = document.querySelector(".render-error");
+  if (overlay) {
+    overlay.remove();
+  }
+}
+
+function refreshContent(event) {
+  const message = JSON.parse(event.data);
+  if (message.type === "reload_images") {
+    reloadImages(message.images);
+    return;
+  }
+  if (message.type === "error") {
+    showRenderError(message.file, message.error);
+    return;
+  }
+
+  hideRenderError();
+  let contentDiv = document.querySelector(".markdown-body");
+  contentDiv.innerHTML = message.html || "";
+
+  addCopyCodeButtons();
+  removeBulletPointsFromTaskListItem();
+  setAttributeAllNodes("img", "referrerpolicy", "no-referrer");
+  prefixRootPaths();
+  applyImageVersions();
+  highlightLines();
+  setupTables();
+}
+
+function cleanup(event) {
+  stream.close();
+
+  // Change content area with an error message saying the server is closed.
+  let contentDiv = document.querySelector(".markdown-body");
+  contentDiv.innerHTML = `You have been disconnected.`;
+
+  // Close the window/tab that was directly opened by the tool.
+  window.close();
+}
+
+const toggle = document.querySelector(".switch > input");
+toggle.addEventListener("click", () => {
+  const currTheme = document.documentElement.getAttribute("data-theme");
+  if (!currTheme || currTheme === "light") {
+    document.documentElement.setAttribute("data-theme", "dark");
+  } else {
+    document.documentElement.setAttribute("data-theme", "light");
+  }
+});
+
+// Apply focus styling the slider thumb.
+const thumb = document.querySelector(".thumb");
+toggle.addEventListener("focus", () => {
+  thumb.classList.add("thumb-active");
+});
+toggle.addEventListener("focusout", () => {
+  thumb.classList.remove("thumb-active");
+});
@@ -1,15 +1,18 @@
 package service
 
 import (
+	"bytes"
 	"embed"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 
 	"spamd/service/config"
+	"spamd/service/middleware"
 )
 
 var (
@@ -25,19 +28,32 @@ var (
 func serveLocalImage(w http.ResponseWriter, r *http.Request) {
 	wd, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("Failed to get working directory. %+v\n", err)
+		slog.Error("Failed to get working directory", "error", err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// Opens the image file relative to current directory.
-	img, err := os.Open(path.Join(wd, r.URL.Path))
-	if err != nil {
-		log.Printf("%+v\n", err)
-		w.WriteHeader(http.StatusNotFound)
-		return
+	var img io.Reader
+	if p := r.URL.Path[1:]; isRevisionPath(p) {
+		// Reads the image from the revision.
+		data, err := readSource(p)
+		if err != nil {
+			slog.Warn("Failed to read image", "error", err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		img = bytes.NewReader(data)
+	} else {
+		// Opens the image file relative to current directory.
+		file, err := os.Open(path.Join(wd, r.URL.Path))
+		if err != nil {
+			slog.Warn("Failed to read image", "error", err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		img = file
 	}
-	defer img.Close()
 
 	contentType := "image/"
 
@@ -57,32 +73,93 @@ func serveLocalImage(w http.ResponseWriter, r *http.Request) {
 }
 
 func serveCSS(w http.ResponseWriter, r *http.Request) {
-	githubMarkdownCSS, err := f.ReadFile(fsPrefix + "/" + "styles.css")
+	styles, err := pageStyles()
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("404 - Failed to read from styles.css"))
 		return
 	}
 	w.Header().Set("Content-Type", "text/css")
-	w.Write(githubMarkdownCSS)
+	w.Write(styles)
 }
 
-func serveHTML(w http.ResponseWriter, r *http.Request) {
-	mainHTML, err := f.ReadFile(fsPrefix + "/" + "index.html")
+func serveJS(w http.ResponseWriter, r *http.Request) {
+	mainJS, err := f.ReadFile(fsPrefix + "/" + "main.js")
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("404 - Failed to read from index.html"))
+		w.Write([]byte("404 - Failed to read from main.js"))
 		return
 	}
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Write(mainJS)
+}
+
+// Writes the page (index.html) with data. Pages served live are
+// filled in by main.js, while standalone pages (see Render) come with
+// their content and styles.
+func writePage(w io.Writer, data map[string]any) error {
+	mainHTML, err := f.ReadFile(fsPrefix + "/" + "index.html")
+	if err != nil {
+		return err
+	}
 
-	t := template.New("Main HTML template")
-	t, _ = t.Parse(string(mainHTML))
+	t, err := template.New("Main HTML template").Parse(string(mainHTML))
+	if err != nil {
+		return err
+	}
 
-	w.Header().Set("Content-Type", "text/html")
-	t.Execute(w, map[string]string{"Filename": path.Base(r.URL.Path),
+	return t.Execute(w, data)
+}
+
+// Returns the styles of the page.
+func pageStyles() ([]byte, error) {
+	githubMarkdownCSS, err := f.ReadFile(fsPrefix + "/" + "styles.css")
+	if err != nil {
+		return nil, err
+	}
+	if serviceConfig.Safe {
+		githubMarkdownCSS = append(githubMarkdownCSS, codeBlockCSS(serviceConfig.CodeBlockTheme)...)
+	}
+
+	return githubMarkdownCSS, nil
+}
+
+func serveHTML(w http.ResponseWriter, r *http.Request) {
+	filename := path.Base(r.URL.Path)
+	if ref, _, ok := parseRevisionPath(r.URL.Path[1:]); ok {
+		filename += " @" + ref
+	}
+	query := ""
+	if refs, ok := r.URL.Query()[diff_param]; ok {
+		// Diff view (see diff.go) is streamed on the same route.
+		ref := default_diff_ref
+		if len(refs[0]) > 0 {
+			ref = refs[0]
+		}
+		filename += " (diff against " + ref + ")"
+		query = "?" + url.Values{diff_param: {ref}}.Encode()
+	}
+
+	// Behind a reverse proxy, every URL starts with the base path.
+	basePath := middleware.BasePath(r)
+
+	var page bytes.Buffer
+	err := writePage(&page, map[string]any{"Filename": filename,
 		"URI":           r.URL.Path,
+		"Query":         query,
 		"Theme":         serviceConfig.Theme,
-		"RefreshPrefix": config.RefreshPrefix,
-		"StylesPrefix":  config.StylesPrefix,
+		"BasePath":      basePath,
+		"RefreshPrefix": basePath + config.RefreshPrefix,
+		"StylesPrefix":  basePath + config.StylesPrefix,
+		"ScriptsPrefix": basePath + config.ScriptsPrefix,
+		"Nonce":         middleware.Nonce(r),
 	})
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Failed to read from index.html"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	page.WriteTo(w)
 }
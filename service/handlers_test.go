@@ -4,10 +4,12 @@ import (
 	"embed"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 
 	testtools "spamd/internal/testing"
+	"spamd/service/middleware"
 )
 
 var (
@@ -69,6 +71,32 @@ func TestServeCSS_ErrOnMissingFS(t *testing.T) {
 	}
 }
 
+func TestGetEmbeddedJS(t *testing.T) {
+	fsMutex.Lock()
+	defer fsMutex.Unlock()
+
+	// During testing, use this static testing folder instead.
+	f = testtools.MockFS
+
+	rr := testtools.MockRequest(t,
+		"GET",
+		"/__/scripts",
+		http.HandlerFunc(serveJS),
+	)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("serveJS returned wrong status code. Expected: %d. Got: %d.", http.StatusOK, status)
+	}
+
+	if got, want := rr.Header().Get("Content-Type"), "text/javascript"; got != want {
+		t.Errorf("serveJS returned wrong Content-Type. Expected %s. Got %s.", want, got)
+	}
+
+	if got, want := rr.Body.String(), "console.log(\"main\");\n"; got != want {
+		t.Errorf("serveJS returned wrong body:\nExpected %s.\n--\nGot %s.", want, got)
+	}
+}
+
 func TestGetEmbeddedHTML(t *testing.T) {
 	fsMutex.Lock()
 	defer fsMutex.Unlock()
@@ -112,6 +140,28 @@ func TestGetEmbeddedHTML(t *testing.T) {
 	}
 }
 
+func TestServeHTMLAtBasePath(t *testing.T) {
+	fsMutex.Lock()
+	defer fsMutex.Unlock()
+	f = testtools.MockFS
+
+	rr := testtools.MockRequest(t,
+		"GET",
+		"/preview/alice/README.md",
+		middleware.NewProxyHeaders(http.HandlerFunc(serveHTML), middleware.ProxyOptions{BasePath: "/preview/alice/"}),
+	)
+
+	for _, want := range []string{
+		`<div class="app">/README.md</div>`,
+		"<div>/preview/alice/__/refresh</div>",
+		"<div>/preview/alice/__/styles</div>",
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("got %s; want to contain %s", rr.Body.String(), want)
+		}
+	}
+}
+
 func TestServeHTML_ErrOnMissingFS(t *testing.T) {
 	var fakeFS embed.FS
 	// Change to non-existent folder
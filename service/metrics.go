@@ -0,0 +1,244 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"spamd/service/config"
+)
+
+// Rendered files that are not documents (see markupFormats) are
+// shown as code.
+const code_format = "code"
+
+var (
+	// Upper bounds (in seconds) of the render latency buckets.
+	renderBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+	metrics = newServiceMetrics()
+)
+
+// Render latencies in the cumulative form of a Prometheus histogram.
+type histogram struct {
+	counts []uint64 // One per renderBuckets.
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range renderBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+type lastRender struct {
+	File     string        `json:"file"`
+	Duration time.Duration `json:"duration_ns"`
+	At       time.Time     `json:"at"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type serviceMetrics struct {
+	mu sync.Mutex
+
+	started time.Time
+	version string
+
+	// Per format (see formatName).
+	renders      map[string]*histogram
+	renderErrors map[string]uint64
+	last         *lastRender
+
+	// Websocket connections opened since the server started.
+	connsOpened uint64
+}
+
+func newServiceMetrics() *serviceMetrics {
+	return &serviceMetrics{
+		started:      time.Now(),
+		renders:      make(map[string]*histogram),
+		renderErrors: make(map[string]uint64),
+	}
+}
+
+// Returns the name of the format filepath is rendered with.
+func formatName(filepath string) string {
+	if format := formatOf(filepath); format != nil {
+		return format.name
+	}
+	return code_format
+}
+
+func (m *serviceMetrics) ObserveRender(filepath string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	format := formatName(filepath)
+	h, ok := m.renders[format]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(renderBuckets))}
+		m.renders[format] = h
+	}
+	h.observe(duration.Seconds())
+
+	m.last = &lastRender{File: filepath, Duration: duration, At: time.Now()}
+	if err != nil {
+		m.renderErrors[format]++
+		m.last.Error = err.Error()
+	}
+}
+
+func (m *serviceMetrics) ConnOpened() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connsOpened++
+}
+
+// Returns the number of connections to each watched file.
+func (f *fileWatcher) ConnCounts() map[string]int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	counts := make(map[string]int)
+	for filepath, cluster := range f.files {
+		counts[filepath] = len(cluster.conns)
+	}
+	return counts
+}
+
+type watchedFile struct {
+	Path        string `json:"path"`
+	Connections int    `json:"connections"`
+}
+
+// Lookups of the render cache (see cache.go).
+type cacheStats struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+type status struct {
+	Version       string                `json:"version"`
+	Started       time.Time             `json:"started"`
+	UptimeSeconds float64               `json:"uptime_seconds"`
+	Dir           string                `json:"dir"` // Working directory.
+	Config        *config.ServiceConfig `json:"config"`
+	Files         []watchedFile         `json:"files"`
+	LastRender    *lastRender           `json:"last_render"`
+	Cache         cacheStats            `json:"cache"`
+}
+
+func (m *serviceMetrics) status(counts map[string]int) status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := []watchedFile{}
+	for filepath, n := range counts {
+		files = append(files, watchedFile{filepath, n})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	var last *lastRender
+	if m.last != nil {
+		copied := *m.last
+		last = &copied
+	}
+
+	hits, misses, hitRate := cache.Stats()
+	return status{
+		Version:       m.version,
+		Started:       m.started,
+		UptimeSeconds: time.Since(m.started).Seconds(),
+		Dir:           workingDir(),
+		Config:        serviceConfig,
+		Files:         files,
+		LastRender:    last,
+		Cache:         cacheStats{hits, misses, hitRate},
+	}
+}
+
+// Writes the metrics in the Prometheus text format.
+func (m *serviceMetrics) WriteTo(w io.Writer, counts map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	formats := make([]string, 0, len(m.renders))
+	for format := range m.renders {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	fmt.Fprintln(w, "# HELP spamd_render_duration_seconds Time taken to render a file for a tab.")
+	fmt.Fprintln(w, "# TYPE spamd_render_duration_seconds histogram")
+	for _, format := range formats {
+		h := m.renders[format]
+		for i, bound := range renderBuckets {
+			fmt.Fprintf(w, "spamd_render_duration_seconds_bucket{format=%q,le=%q} %d\n", format, fmt.Sprint(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "spamd_render_duration_seconds_bucket{format=%q,le=\"+Inf\"} %d\n", format, h.count)
+		fmt.Fprintf(w, "spamd_render_duration_seconds_sum{format=%q} %g\n", format, h.sum)
+		fmt.Fprintf(w, "spamd_render_duration_seconds_count{format=%q} %d\n", format, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP spamd_render_errors_total Renders that failed.")
+	fmt.Fprintln(w, "# TYPE spamd_render_errors_total counter")
+	for _, format := range formats {
+		fmt.Fprintf(w, "spamd_render_errors_total{format=%q} %d\n", format, m.renderErrors[format])
+	}
+
+	hits, misses, _ := cache.Stats()
+	fmt.Fprintln(w, "# HELP spamd_render_cache_hits_total Renders served from the render cache.")
+	fmt.Fprintln(w, "# TYPE spamd_render_cache_hits_total counter")
+	fmt.Fprintf(w, "spamd_render_cache_hits_total %d\n", hits)
+	fmt.Fprintln(w, "# HELP spamd_render_cache_misses_total Renders that had to convert the file.")
+	fmt.Fprintln(w, "# TYPE spamd_render_cache_misses_total counter")
+	fmt.Fprintf(w, "spamd_render_cache_misses_total %d\n", misses)
+
+	conns := 0
+	for _, n := range counts {
+		conns += n
+	}
+	fmt.Fprintln(w, "# HELP spamd_websocket_connections Open websocket connections (one per tab).")
+	fmt.Fprintln(w, "# TYPE spamd_websocket_connections gauge")
+	fmt.Fprintf(w, "spamd_websocket_connections %d\n", conns)
+	fmt.Fprintln(w, "# HELP spamd_websocket_connections_total Websocket connections opened.")
+	fmt.Fprintln(w, "# TYPE spamd_websocket_connections_total counter")
+	fmt.Fprintf(w, "spamd_websocket_connections_total %d\n", m.connsOpened)
+	fmt.Fprintln(w, "# HELP spamd_watched_files Files watched for changes.")
+	fmt.Fprintln(w, "# TYPE spamd_watched_files gauge")
+	fmt.Fprintf(w, "spamd_watched_files %d\n", len(counts))
+	fmt.Fprintln(w, "# HELP spamd_uptime_seconds Time since the server started.")
+	fmt.Fprintln(w, "# TYPE spamd_uptime_seconds gauge")
+	fmt.Fprintf(w, "spamd_uptime_seconds %g\n", time.Since(m.started).Seconds())
+}
+
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(metrics.status(watcher.ConnCounts()), "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var out strings.Builder
+	metrics.WriteTo(&out, watcher.ConnCounts())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.WriteString(w, out.String())
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	testtools "spamd/internal/testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	m := newServiceMetrics()
+	m.ObserveRender("README.md", 3*time.Millisecond, nil)
+	m.ObserveRender("README.md", 2*time.Second, errors.New("failed"))
+	m.ObserveRender("main.go", time.Millisecond, nil)
+	m.ConnOpened()
+
+	var out strings.Builder
+	m.WriteTo(&out, map[string]int{"README.md": 2})
+
+	for _, want := range []string{
+		`spamd_render_duration_seconds_bucket{format="markdown",le="0.001"} 0`,
+		`spamd_render_duration_seconds_bucket{format="markdown",le="0.005"} 1`,
+		`spamd_render_duration_seconds_bucket{format="markdown",le="2.5"} 2`,
+		`spamd_render_duration_seconds_bucket{format="markdown",le="+Inf"} 2`,
+		`spamd_render_duration_seconds_count{format="code"} 1`,
+		`spamd_render_errors_total{format="markdown"} 1`,
+		"spamd_websocket_connections 2",
+		"spamd_websocket_connections_total 1",
+		"spamd_watched_files 1",
+		"# TYPE spamd_render_cache_hits_total counter",
+		"# TYPE spamd_render_cache_misses_total counter",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got %s; want to contain %s", out.String(), want)
+		}
+	}
+}
+
+func TestServeStatus(t *testing.T) {
+	watcher = newFileWatcher(true)
+	watcher.AddConn("README.md", time.Time{}, &MockWebsocketConn{})
+	metrics.ObserveRender("README.md", time.Millisecond, nil)
+
+	rr := testtools.MockRequest(t, "GET", "/__/status", http.HandlerFunc(serveStatus))
+	if got, want := rr.Header().Get("Content-Type"), "application/json"; got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+
+	var got status
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if len(got.Files) != 1 || got.Files[0] != (watchedFile{"README.md", 1}) {
+		t.Errorf("got %v; want README.md with 1 connection", got.Files)
+	}
+	if got.LastRender == nil || got.LastRender.File != "README.md" {
+		t.Errorf("got %v; want last render of README.md", got.LastRender)
+	}
+	if got.Config == nil {
+		t.Error("got <nil>; want the config")
+	}
+	if hits, misses, _ := cache.Stats(); got.Cache.Hits != hits || got.Cache.Misses != misses {
+		t.Errorf("got %+v; want %d hits, %d misses", got.Cache, hits, misses)
+	}
+}
@@ -1,26 +1,99 @@
 package middleware
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
+type requestIDKey struct{}
+
 // Logger is a middleware handler that does request logging
 type Logger struct {
 	handler http.Handler
+
+	// Last request ID given.
+	lastID atomic.Uint64
+}
+
+// responseRecorder keeps the status code and the number of bytes
+// of the response written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Hijack lets websocket connections take over the underlying
+// connection.
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T cannot be hijacked", rec.ResponseWriter)
+	}
+
+	rec.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// RequestID returns the ID given to r by Logger (also sent back in
+// the X-Request-Id header), or 0 if r did not go through Logger.
+func RequestID(r *http.Request) uint64 {
+	id, _ := r.Context().Value(requestIDKey{}).(uint64)
+	return id
 }
 
 // ServeHTTP handles the request by passing it to the real
 // handler and logging the request details
 func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	l.handler.ServeHTTP(w, r)
-	log.Printf("%s %s %v", r.Method, r.URL.Path, time.Since(start))
+	id := l.lastID.Add(1)
+	w.Header().Set("X-Request-Id", fmt.Sprint(id))
+
+	rec := &responseRecorder{ResponseWriter: w}
+	l.handler.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
 
+	slog.Info("Request",
+		"id", id,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"remote", r.RemoteAddr,
+		"status", rec.status,
+		"bytes", rec.bytes,
+		"duration", time.Since(start))
 }
 
 // NewLogger constructs a new Logger middleware handler
 func NewLogger(handler http.Handler) *Logger {
-	return &Logger{handler}
+	return &Logger{handler: handler}
 }
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var out bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&out, nil)))
+	t.Cleanup(func() { slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil))) })
+	return &out
+}
+
+func TestLoggerRecordsResponse(t *testing.T) {
+	out := captureLogs(t)
+	handler := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestID(r) != 1 {
+			t.Errorf("got %d; want request ID 1", RequestID(r))
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("missing"))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/README.md", nil))
+
+	if got := rr.Header().Get("X-Request-Id"); got != "1" {
+		t.Errorf("got %s; want 1", got)
+	}
+	for _, want := range []string{"id=1", "method=GET", "path=/README.md", "status=404", "bytes=7"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got %s; want to contain %s", out.String(), want)
+		}
+	}
+}
+
+func TestLoggerAllowsWebsockets(t *testing.T) {
+	out := captureLogs(t)
+	upgraded := make(chan error, 1)
+	logger := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		upgraded <- err
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	// Hijacked connections are not waited for by s.Close(), so the
+	// request is logged once this is closed.
+	logged := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(logged)
+		logger.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	ws.Close()
+	if err := <-upgraded; err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	<-logged
+	if !strings.Contains(out.String(), "status=101") {
+		t.Errorf("got %s; want to contain status=101", out.String())
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"spamd/service/config"
+)
+
+type basePathKey struct{}
+
+type ProxyOptions struct {
+	// Path the server is mounted at, e.g. "/preview/alice".
+	BasePath string
+	// Honors the X-Forwarded-* headers. Only set behind a reverse
+	// proxy, since any client could send them otherwise.
+	TrustHeaders bool
+}
+
+// ProxyHeaders is a middleware handler for servers behind a reverse
+// proxy. It strips the base path the server is mounted at from request
+// paths (if the proxy did not already) and honors the X-Forwarded-*
+// headers set by the proxy (if trusted).
+type ProxyHeaders struct {
+	handler http.Handler
+	options ProxyOptions
+}
+
+// BasePath returns the path the server is mounted at for r, e.g.
+// "/preview/alice", or "" if it is served at the root.
+func BasePath(r *http.Request) string {
+	basePath, _ := r.Context().Value(basePathKey{}).(string)
+	return basePath
+}
+
+// ServeHTTP passes the request, relative to the base path, to the
+// real handler.
+func (p *ProxyHeaders) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	basePath := p.options.BasePath
+	r = r.Clone(r.Context())
+	if p.options.TrustHeaders {
+		if prefix := r.Header.Get("X-Forwarded-Prefix"); prefix != "" {
+			basePath = config.CleanBasePath(prefix)
+		}
+		// Websocket upgrades check that the page comes from this host.
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			r.Host = strings.TrimSpace(strings.Split(host, ",")[0])
+		}
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			r.URL.Scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+		}
+		// The first address is the client's.
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			if ip := net.ParseIP(strings.TrimSpace(strings.Split(forwarded, ",")[0])); ip != nil {
+				r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+			}
+		}
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), basePathKey{}, basePath))
+	if basePath != "" && (r.URL.Path == basePath || strings.HasPrefix(r.URL.Path, basePath+"/")) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, basePath)
+		r.URL.RawPath = ""
+		if r.URL.Path == "" {
+			r.URL.Path = "/"
+		}
+	}
+
+	p.handler.ServeHTTP(w, r)
+}
+
+// NewProxyHeaders constructs a new ProxyHeaders middleware handler
+func NewProxyHeaders(handler http.Handler, options ProxyOptions) *ProxyHeaders {
+	options.BasePath = config.CleanBasePath(options.BasePath)
+	return &ProxyHeaders{handler, options}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	cases := []struct {
+		basePath string
+		path     string
+		header   http.Header
+		wantPath string
+		wantBase string
+	}{
+		{"/preview/alice", "/preview/alice/docs/a.md", nil, "/docs/a.md", "/preview/alice"},
+		{"/preview/alice", "/preview/alice", nil, "/", "/preview/alice"},
+		// Stripped by the proxy already.
+		{"/preview/alice", "/docs/a.md", nil, "/docs/a.md", "/preview/alice"},
+		// Not a path segment of the base path.
+		{"/preview", "/previews/a.md", nil, "/previews/a.md", "/preview"},
+		{"", "/bob/a.md", http.Header{"X-Forwarded-Prefix": {"/bob/"}}, "/a.md", "/bob"},
+	}
+
+	for _, c := range cases {
+		var gotPath, gotBase string
+		handler := NewProxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath, gotBase = r.URL.Path, BasePath(r)
+		}), ProxyOptions{BasePath: c.basePath, TrustHeaders: true})
+
+		req := httptest.NewRequest("GET", c.path, nil)
+		for name, values := range c.header {
+			req.Header[name] = values
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if gotPath != c.wantPath || gotBase != c.wantBase {
+			t.Errorf("%s at %s: got %s at %s; want %s at %s", c.path, c.basePath, gotPath, gotBase, c.wantPath, c.wantBase)
+		}
+	}
+}
+
+func TestProxyHeadersForwarded(t *testing.T) {
+	var got *http.Request
+	handler := NewProxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}), ProxyOptions{TrustHeaders: true})
+
+	req := httptest.NewRequest("GET", "/a.md", nil)
+	req.Header.Set("X-Forwarded-Host", "devbox, proxy")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-For", "10.0.0.7, 10.0.0.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Host != "devbox" || got.URL.Scheme != "https" || got.RemoteAddr != "10.0.0.7:0" {
+		t.Errorf("got %s, %s, %s; want devbox, https, 10.0.0.7:0", got.Host, got.URL.Scheme, got.RemoteAddr)
+	}
+}
+
+func TestProxyHeadersIgnoredIfNotTrusted(t *testing.T) {
+	var got *http.Request
+	handler := NewProxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}), ProxyOptions{})
+
+	req := httptest.NewRequest("GET", "/evil/a.md", nil)
+	req.Header.Set("X-Forwarded-Prefix", "/evil")
+	req.Header.Set("X-Forwarded-Host", "evil.example.com")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-For", "10.0.0.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.URL.Path != "/evil/a.md" || BasePath(got) != "" || got.Host != req.Host || got.URL.Scheme != "" || got.RemoteAddr != req.RemoteAddr {
+		t.Errorf("got %s at %q, %s, %s, %s; want the request unchanged", got.URL.Path, BasePath(got), got.Host, got.URL.Scheme, got.RemoteAddr)
+	}
+}
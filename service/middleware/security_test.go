@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	var nonce string
+	handler := NewSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = Nonce(r)
+	}), SecurityOptions{})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/README.md", nil))
+
+	if nonce == "" {
+		t.Error("got empty nonce; want nonce passed to handler")
+	}
+	csp := rr.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'nonce-"+nonce+"'") {
+		t.Errorf("got CSP %s; want script-src with nonce %s", csp, nonce)
+	}
+	if !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("got CSP %s; want frame-ancestors 'none'", csp)
+	}
+	if got := rr.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("got X-Frame-Options %s; want DENY", got)
+	}
+	if got := rr.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("got X-Content-Type-Options %s; want nosniff", got)
+	}
+	if got := rr.Header().Get("Referrer-Policy"); got != "no-referrer" {
+		t.Errorf("got Referrer-Policy %s; want no-referrer", got)
+	}
+}
+
+func TestSecurityHeadersAllowFrameAncestors(t *testing.T) {
+	handler := NewSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		SecurityOptions{FrameAncestors: []string{"vscode-webview:", "'self'"}})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/README.md", nil))
+
+	csp := rr.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "frame-ancestors vscode-webview: 'self'") {
+		t.Errorf("got CSP %s; want configured frame-ancestors", csp)
+	}
+	if got := rr.Header().Get("X-Frame-Options"); got != "" {
+		t.Errorf("got X-Frame-Options %s; want none", got)
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches ANSI color codes in tracebacks.
+	ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+	// MIME types of the images in outputs, in order of preference.
+	notebookImageTypes = []string{"image/png", "image/jpeg", "image/gif"}
+)
+
+// Multi-line strings in notebooks are either a string or
+// a list of lines.
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*t = notebookText(strings.Join(lines, ""))
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*t = notebookText(text)
+	return nil
+}
+
+// The parts of a Jupyter notebook (nbformat 4) that are displayed.
+type notebook struct {
+	Format   int `json:"nbformat"`
+	Metadata struct {
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+		Kernelspec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+	} `json:"metadata"`
+	Cells []notebookCell `json:"cells"`
+}
+
+type notebookCell struct {
+	Type           string           `json:"cell_type"`
+	Source         notebookText     `json:"source"`
+	ExecutionCount *int             `json:"execution_count"`
+	Outputs        []notebookOutput `json:"outputs"`
+}
+
+type notebookOutput struct {
+	Type string `json:"output_type"`
+	// Of stream outputs: "stdout" or "stderr".
+	Name string       `json:"name"`
+	Text notebookText `json:"text"`
+	// Of execute_result and display_data outputs, by MIME type.
+	Data map[string]notebookText `json:"data"`
+	// Of error outputs.
+	Ename     string   `json:"ename"`
+	Evalue    string   `json:"evalue"`
+	Traceback []string `json:"traceback"`
+}
+
+// Returns the language of the code cells.
+func (nb *notebook) language() string {
+	if nb.Metadata.LanguageInfo.Name != "" {
+		return nb.Metadata.LanguageInfo.Name
+	}
+	if nb.Metadata.Kernelspec.Language != "" {
+		return nb.Metadata.Kernelspec.Language
+	}
+	return "python"
+}
+
+// Converts a Jupyter notebook into HTML: markdown cells are converted
+// like markdowns, code cells are highlighted with the configured code
+// block style and followed by their outputs.
+//
+// In safe mode, images in outputs (PNG, JPEG or GIF data URLs) are
+// kept by sanitize() while raw HTML is stripped. HTML outputs are
+// omitted unless the "unsafe" extension is enabled.
+func convertNotebook(key string, source []byte) ([]byte, error) {
+	return convertCached(key, source, func(source []byte) ([]byte, error) {
+		var nb notebook
+		if err := json.Unmarshal(source, &nb); err != nil {
+			return nil, fmt.Errorf("Error parsing notebook %s: %s", key, err)
+		}
+		if nb.Format < 4 {
+			return nil, fmt.Errorf("Notebook %s has format %d; only 4 and above are supported.", key, nb.Format)
+		}
+
+		var content bytes.Buffer
+		var notCached error
+		content.WriteString(`<div class="notebook">`)
+		for _, cell := range nb.Cells {
+			err := writeNotebookCell(&content, nb.language(), cell)
+			if errors.Is(err, errNotCached) {
+				notCached = err
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		content.WriteString(`</div>`)
+		return content.Bytes(), notCached
+	})
+}
+
+// Returns errNotCached (see convertCached) once the cell is written
+// if markdown in it was rendered by a fallback.
+func writeNotebookCell(w *bytes.Buffer, language string, cell notebookCell) error {
+	var notCached error
+	switch cell.Type {
+	case "markdown":
+		w.WriteString(`<div class="nb-cell nb-markdown">`)
+		err := converter([]byte(cell.Source), w)
+		if errors.Is(err, errNotCached) {
+			notCached = err
+		} else if err != nil {
+			return err
+		}
+		w.WriteString(`</div>`)
+
+	case "code":
+		w.WriteString(`<div class="nb-cell nb-code">`)
+		writeNotebookPrompt(w, "In", cell.ExecutionCount)
+		fmt.Fprintf(w, `<div class="nb-input">%s</div>`, highlightSource(language, string(cell.Source)))
+
+		for _, output := range cell.Outputs {
+			w.WriteString(`<div class="nb-output">`)
+			if output.Type == "execute_result" {
+				writeNotebookPrompt(w, "Out", cell.ExecutionCount)
+			}
+			err := writeNotebookOutput(w, output)
+			if errors.Is(err, errNotCached) {
+				notCached = err
+			} else if err != nil {
+				return err
+			}
+			w.WriteString(`</div>`)
+		}
+		w.WriteString(`</div>`)
+
+	default:
+		// Raw cells are shown as is.
+		fmt.Fprintf(w, `<div class="nb-cell nb-raw"><pre>%s</pre></div>`, html.EscapeString(string(cell.Source)))
+	}
+
+	return notCached
+}
+
+func writeNotebookPrompt(w *bytes.Buffer, label string, count *int) {
+	n := " "
+	if count != nil {
+		n = fmt.Sprint(*count)
+	}
+	fmt.Fprintf(w, `<div class="nb-prompt">%s [%s]:</div>`, label, n)
+}
+
+// Writes the richest representation of an output that can be shown.
+func writeNotebookOutput(w *bytes.Buffer, output notebookOutput) error {
+	switch output.Type {
+	case "stream":
+		fmt.Fprintf(w, `<pre class="nb-%s">%s</pre>`, html.EscapeString(output.Name), html.EscapeString(string(output.Text)))
+		return nil
+
+	case "error":
+		traceback := ansiEscapeRegex.ReplaceAllString(strings.Join(output.Traceback, "\n"), "")
+		if traceback == "" {
+			traceback = output.Ename + ": " + output.Evalue
+		}
+		fmt.Fprintf(w, `<pre class="nb-error">%s</pre>`, html.EscapeString(traceback))
+		return nil
+	}
+
+	for _, mimeType := range notebookImageTypes {
+		if data, ok := output.Data[mimeType]; ok {
+			// Base64 encoded, possibly split across lines.
+			fmt.Fprintf(w, `<img src="data:%s;base64,%s">`, mimeType, strings.Join(strings.Fields(string(data)), ""))
+			return nil
+		}
+	}
+	// Raw HTML is only written if allowed like in markdowns (and is
+	// sanitized in safe mode, see convertCached). Otherwise, the other
+	// representations of the output are used.
+	rawHTML, hasHTML := output.Data["text/html"]
+	if hasHTML && serviceConfig.ExtensionEnabled("unsafe") {
+		w.WriteString(string(rawHTML))
+		return nil
+	}
+	if data, ok := output.Data["text/markdown"]; ok {
+		return converter([]byte(data), w)
+	}
+	if data, ok := output.Data["text/plain"]; ok {
+		fmt.Fprintf(w, `<pre>%s</pre>`, html.EscapeString(string(data)))
+	} else if hasHTML {
+		w.WriteString("<!-- raw HTML omitted -->")
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+const testNotebook = `{
+  "nbformat": 4,
+  "nbformat_minor": 5,
+  "metadata": {"language_info": {"name": "python"}},
+  "cells": [
+    {"cell_type": "markdown", "metadata": {}, "source": ["# Title\n", "Some *text*"]},
+    {
+      "cell_type": "code",
+      "execution_count": 3,
+      "metadata": {},
+      "source": "print(1 < 2)",
+      "outputs": [
+        {"output_type": "stream", "name": "stdout", "text": ["True\n"]},
+        {"output_type": "execute_result", "execution_count": 3, "metadata": {}, "data": {"text/plain": "<b>"}},
+        {"output_type": "display_data", "metadata": {}, "data": {"image/png": "iVBORw0K\nGgo=\n", "text/plain": "<Figure>"}},
+        {"output_type": "error", "ename": "ValueError", "evalue": "bad", "traceback": ["\u001b[0;31mValueError\u001b[0m: bad"]}
+      ]
+    },
+    {"cell_type": "raw", "metadata": {}, "source": "<raw>"}
+  ]
+}`
+
+func TestConvertNotebook(t *testing.T) {
+	got, err := convertNotebook("test.ipynb", []byte(testNotebook))
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	for _, want := range []string{
+		`<div class="nb-cell nb-markdown"><h1 id="title">Title</h1>`,
+		`<em>text</em>`,
+		`<div class="nb-prompt">In [3]:</div>`,
+		`>&lt;</span>`,
+		`<pre class="nb-stdout">True`,
+		`<div class="nb-prompt">Out [3]:</div><pre>&lt;b&gt;</pre>`,
+		`<img src="data:image/png;base64,iVBORw0KGgo=">`,
+		`<pre class="nb-error">ValueError: bad</pre>`,
+		`<pre>&lt;raw&gt;</pre>`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+}
+
+func TestNotebookHTMLOutputHonorsUnsafe(t *testing.T) {
+	confMu.Lock()
+	savedExtensions := serviceConfig.Extensions
+	defer func() {
+		serviceConfig.Extensions = savedExtensions
+		confMu.Unlock()
+	}()
+	source := `{"nbformat": 4, "metadata": {}, "cells": [{"cell_type": "code", "metadata": {}, "source": "df", "outputs": [
+		{"output_type": "display_data", "metadata": {}, "data": {"text/html": "<table onclick=\"x()\"></table>", "text/plain": "df"}},
+		{"output_type": "display_data", "metadata": {}, "data": {"text/html": "<script>x()</script>"}}
+	]}]}`
+
+	serviceConfig.Extensions = map[string]bool{"unsafe": true}
+	got, _ := convertNotebook("test.ipynb", []byte(source))
+	if !strings.Contains(string(got), `<table onclick="x()"></table>`) {
+		t.Errorf("got %s; want the HTML output", got)
+	}
+
+	serviceConfig.Extensions = map[string]bool{"unsafe": false}
+	got, _ = convertNotebook("test.ipynb", []byte(source))
+	if strings.Contains(string(got), "<table") || strings.Contains(string(got), "<script") {
+		t.Errorf("got %s; want no raw HTML", got)
+	}
+	for _, want := range []string{"<pre>df</pre>", "<!-- raw HTML omitted -->"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want to contain %s", got, want)
+		}
+	}
+}
+
+func TestNotebookImageInSafeMode(t *testing.T) {
+	confMu.Lock()
+	savedSafe := serviceConfig.Safe
+	defer func() {
+		serviceConfig.Safe = savedSafe
+		confMu.Unlock()
+	}()
+	serviceConfig.Safe = true
+
+	got, err := convertNotebook("safe.ipynb", []byte(testNotebook))
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if want := `<img src="data:image/png;base64,iVBORw0KGgo=">`; !strings.Contains(string(got), want) {
+		t.Errorf("got %s; want to contain %s", got, want)
+	}
+}
+
+func TestConvertInvalidNotebook(t *testing.T) {
+	for _, source := range []string{`{"cells": [`, `{"nbformat": 3, "worksheets": []}`} {
+		if _, err := convertNotebook("test.ipynb", []byte(source)); err == nil {
+			t.Errorf("convertNotebook(%s): got <nil>; want error", source)
+		}
+	}
+}
@@ -2,56 +2,216 @@ package service
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"os"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
 	"sync"
 
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	chromastyles "github.com/alecthomas/chroma/styles"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark-emoji"
 	"github.com/yuin/goldmark-highlighting"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
+
+	"spamd/service/config"
 )
 
 var (
-	converterMutex sync.Mutex
+	// Matches the source of every <img> element in the converted HTML.
+	imageSrcRegex = regexp.MustCompile(`<img[^>]*\ssrc=["']([^"']+)["']`)
+
+	markdown = &markdownRenderer{}
 
 	// A function that transforms a sequence of bytes into
 	// markdown content.
 	converter = func(filedata []byte, content *bytes.Buffer) error {
-		md := goldmark.New(
-			goldmark.WithExtensions(
-				extension.GFM,
-				extension.TaskList,
-				extension.Footnote,
-				highlighting.NewHighlighting(
-					highlighting.WithStyle(serviceConfig.CodeBlockTheme), // Code highlight colors
-				),
-				emoji.Emoji,
-			),
-			goldmark.WithParserOptions(
-				parser.WithAutoHeadingID(),
-			),
-			goldmark.WithRendererOptions(
-				html.WithUnsafe(),
-			),
-		)
-
-		return md.Convert(filedata, content)
+		return activeBackend().Render(filedata, content)
 	}
 )
 
+// Holds a single goldmark.Markdown shared by all conversions.
+//
+// Building one (along with its extensions) is expensive compared
+// to converting a document, so it is only rebuilt when the render
+// options change. goldmark.Markdown is safe for concurrent use.
+type markdownRenderer struct {
+	lock    sync.Mutex
+	options string
+	md      goldmark.Markdown
+}
+
+// Returns the goldmark.Markdown for the current render options.
+func (r *markdownRenderer) Get() goldmark.Markdown {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	options := renderOptions()
+	if r.md == nil || r.options != options {
+		r.md = newMarkdown(serviceConfig)
+		r.options = options
+	}
+
+	return r.md
+}
+
+// Options collected from each enabled extension.
+type markdownOptions struct {
+	extensions []goldmark.Extender
+	parser     []parser.Option
+	renderer   []renderer.Option
+}
+
+// Maps each name in config.ExtensionNames to the goldmark
+// extension/options it enables.
+var markdownExtensions = map[string]func(opts *markdownOptions, conf *config.ServiceConfig){
+	"table": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.Table)
+	},
+	"strikethrough": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.Strikethrough)
+	},
+	"linkify": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.Linkify)
+	},
+	"tasklist": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.TaskList)
+	},
+	"footnote": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.Footnote)
+	},
+	"highlighting": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		highlightingOpts := []highlighting.Option{
+			highlighting.WithStyle(conf.CodeBlockTheme), // Code highlight colors
+		}
+		if conf.Safe {
+			// Inline styles are stripped by sanitize(), so colors
+			// are applied through classes (see codeBlockCSS).
+			highlightingOpts = append(highlightingOpts, highlighting.WithFormatOptions(
+				chromahtml.WithClasses(true),
+			))
+		}
+		opts.extensions = append(opts.extensions, highlighting.NewHighlighting(highlightingOpts...))
+	},
+	"emoji": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, emoji.Emoji)
+	},
+	"unsafe": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.renderer = append(opts.renderer, html.WithUnsafe())
+	},
+	"autoheadingid": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.parser = append(opts.parser, parser.WithAutoHeadingID())
+	},
+	"definitionlist": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.DefinitionList)
+	},
+	"typographer": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.Typographer)
+	},
+	"cjk": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.extensions = append(opts.extensions, extension.CJK)
+	},
+	"attribute": func(opts *markdownOptions, conf *config.ServiceConfig) {
+		opts.parser = append(opts.parser, parser.WithAttribute())
+	},
+}
+
+func newMarkdown(conf *config.ServiceConfig) goldmark.Markdown {
+	var opts markdownOptions
+	for _, name := range conf.EnabledExtensions() {
+		markdownExtensions[name](&opts, conf)
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(opts.extensions...),
+		goldmark.WithParserOptions(opts.parser...),
+		goldmark.WithRendererOptions(opts.renderer...),
+	)
+}
+
 func convertMarkdownToHTML(pathToMarkdown string) ([]byte, error) {
-	filedata, err := os.ReadFile(pathToMarkdown)
+	filedata, err := cache.ReadSource(pathToMarkdown)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading %s: %s", pathToMarkdown, err)
 	}
 
-	var content bytes.Buffer
-	if err := converter(filedata, &content); err != nil {
-		return nil, err
+	return convertMarkdown(pathToMarkdown, filedata)
+}
+
+// Converts markdown source into HTML. The result is cached under
+// key (usually the path of the source), until source changes.
+func convertMarkdown(key string, source []byte) ([]byte, error) {
+	return convertCached(key, source, func(source []byte) ([]byte, error) {
+		var content bytes.Buffer
+		err := converter(source, &content)
+		if err != nil && !errors.Is(err, errNotCached) {
+			return nil, err
+		}
+		return content.Bytes(), err
+	})
+}
+
+// Returns the config values that affect the converted HTML.
+// Files converted with different options are not cached together.
+func renderOptions() string {
+	return fmt.Sprintf("%s;%s;safe=%t;%s=%s",
+		serviceConfig.CodeBlockTheme,
+		strings.Join(serviceConfig.EnabledExtensions(), ","),
+		serviceConfig.Safe,
+		serviceConfig.Renderer,
+		serviceConfig.RendererURL,
+	)
+}
+
+// Returns the CSS for the classes of highlighted code blocks.
+// Only required in safe mode, where inline styles are stripped.
+func codeBlockCSS(codeBlockTheme string) []byte {
+	var css bytes.Buffer
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	formatter.WriteCSS(&css, chromastyles.Get(codeBlockTheme))
+
+	// Scope under .markdown-body, so that these take precedence
+	// over the default styles of code blocks.
+	return bytes.ReplaceAll(css.Bytes(), []byte(" .chroma"), []byte(" .markdown-body .chroma"))
+}
+
+// Returns the paths (relative to the current directory) of local
+// images referenced in the HTML converted from pathToMarkdown.
+//
+// Sources are resolved the same way the browser resolves them
+// against the page URL, so that the paths match those requested
+// from serveLocalImage.
+func localImages(pathToMarkdown string, content []byte) []string {
+	var images []string
+	seen := make(map[string]bool)
+
+	for _, match := range imageSrcRegex.FindAllSubmatch(content, -1) {
+		src := string(match[1])
+		u, err := url.Parse(src)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+			// Skip remote and data images.
+			continue
+		}
+
+		var p string
+		if strings.HasPrefix(u.Path, "/") {
+			p = path.Clean(u.Path[1:])
+		} else {
+			p = path.Join(path.Dir(pathToMarkdown), u.Path)
+		}
+
+		if p == "." || p == ".." || strings.HasPrefix(p, "../") || seen[p] {
+			continue
+		}
+		seen[p] = true
+		images = append(images, p)
 	}
 
-	return content.Bytes(), nil
+	return images
 }
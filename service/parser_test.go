@@ -3,8 +3,18 @@ package service
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"os"
+	"reflect"
+	"sync"
 	"testing"
+
+	"spamd/service/config"
+)
+
+var (
+	// Serializes the tests that override converter.
+	converterMutex sync.Mutex
 )
 
 func TestErrorsOnAbsentFile(t *testing.T) {
@@ -60,3 +70,171 @@ func TestConvertIntoMarkdown(t *testing.T) {
 		t.Errorf("got \"%s\"; want \"%s\"", string(got), want)
 	}
 }
+
+func TestLocalImages(t *testing.T) {
+	content := []byte(`<p><img src="/assets/pikachu.png" alt="a">
+<img src="raichu.jpg" alt="b">
+<img alt="c" src='../simpson.gif'>
+<img src="https://example.com/remote.png" alt="d">
+<img src="data:image/png;base64,AAAA" alt="e">
+<img src="/assets/pikachu.png?size=2" alt="f"></p>
+`)
+
+	got := localImages("docs/guide/README.md", content)
+	want := []string{
+		"assets/pikachu.png",
+		"docs/guide/raichu.jpg",
+		"docs/simpson.gif",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestRebuildMarkdownOnConfigChange(t *testing.T) {
+	confMu.Lock()
+	savedCodeStyle := serviceConfig.CodeBlockTheme
+	defer func() {
+		serviceConfig.SetCodeBlockTheme(savedCodeStyle)
+		confMu.Unlock()
+	}()
+
+	renderer := &markdownRenderer{}
+	first := renderer.Get()
+	if renderer.Get() != first {
+		t.Error("Should reuse goldmark.Markdown when config is unchanged.")
+	}
+
+	serviceConfig.SetCodeBlockTheme("xcode")
+	if renderer.Get() == first {
+		t.Error("Should rebuild goldmark.Markdown when config changes.")
+	}
+}
+
+func TestConcurrentConversions(t *testing.T) {
+	source := largeMarkdown(5)
+	var want bytes.Buffer
+	if err := converter(source, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got bytes.Buffer
+			if err := converter(source, &got); err != nil {
+				t.Error(err)
+				return
+			}
+			if got.String() != want.String() {
+				t.Error("Concurrent conversions should produce the same HTML.")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Returns a markdown document made up of n sections containing
+// most of the constructs supported by the converter.
+func largeMarkdown(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "## Section %d\n\n", i)
+		b.WriteString("Some *emphasis*, **strong**, ~~strikethrough~~ and `code` :fire:.\n")
+		b.WriteString("Visit https://example.com for more[^1].\n\n")
+		b.WriteString("- [x] done\n- [ ] todo\n  1. nested\n  2. list\n\n")
+		b.WriteString("| Name | Value |\n| ---- | ----- |\n| a    | 1     |\n| b    | 2     |\n\n")
+		b.WriteString("```go\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n```\n\n")
+		b.WriteString("> A quote with <b>raw html</b>.\n\n")
+		fmt.Fprintf(&b, "[^1]: Footnote %d.\n\n", i)
+	}
+	return b.Bytes()
+}
+
+func BenchmarkConvertLargeDocument(b *testing.B) {
+	source := largeMarkdown(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var content bytes.Buffer
+		if err := converter(source, &content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Builds a new goldmark.Markdown on every conversion (the way it
+// was done before markdownRenderer) for comparison. The saving is a
+// fixed cost per conversion, so it matters most for the frequent
+// re-renders of a file being edited.
+func BenchmarkConvertLargeDocumentRebuild(b *testing.B) {
+	source := largeMarkdown(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var content bytes.Buffer
+		if err := newMarkdown(serviceConfig).Convert(source, &content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConvertSmallDocument(b *testing.B) {
+	source := largeMarkdown(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var content bytes.Buffer
+		if err := converter(source, &content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConvertSmallDocumentRebuild(b *testing.B) {
+	source := largeMarkdown(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var content bytes.Buffer
+		if err := newMarkdown(serviceConfig).Convert(source, &content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEveryExtensionIsMapped(t *testing.T) {
+	for _, name := range config.ExtensionNames {
+		if _, ok := markdownExtensions[name]; !ok {
+			t.Errorf("Extension %s has no goldmark mapping.", name)
+		}
+	}
+}
+
+func TestToggleExtensions(t *testing.T) {
+	source := []byte(`Term
+: Definition
+
+<b>raw</b>
+`)
+	conf := &config.ServiceConfig{
+		CodeBlockTheme: "monokai",
+		Extensions: map[string]bool{
+			"definitionlist": true,
+			"unsafe":         false,
+		},
+	}
+
+	var content bytes.Buffer
+	if err := newMarkdown(conf).Convert(source, &content); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<dl>
+<dt>Term</dt>
+<dd>Definition</dd>
+</dl>
+<p><!-- raw HTML omitted -->raw<!-- raw HTML omitted --></p>
+`
+	if content.String() != want {
+		t.Errorf("got %s; want %s", content.String(), want)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+
+	"spamd/internal/options"
+	"spamd/internal/sys"
+)
+
+// Name of the markdown read from stdin (by "spamd render -").
+const stdin_name = "stdin"
+
+// Runs "spamd render": prints the HTML of a file (or of the markdown
+// read from stdin) to stdout. Exits with 1 if it cannot be read or
+// rendered.
+func Render(opts *options.Options) {
+	overrideConfig(opts)
+
+	filename, content, err := renderInput(opts.Render.File, os.Stdin)
+	if err != nil {
+		sys.ErrorAndExit(err.Error())
+	}
+
+	if err := writeRendered(os.Stdout, filename, content, opts.Render.Full); err != nil {
+		sys.ErrorAndExit(err.Error())
+	}
+}
+
+// Renders the file at filepath, or the markdown read from stdin if
+// filepath is "-". Returns the name of the document with its HTML.
+func renderInput(filepath string, stdin io.Reader) (string, []byte, error) {
+	if filepath != "-" {
+		content, err := renderFile(filepath)
+		return path.Base(filepath), content, err
+	}
+
+	source, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error reading %s: %s", stdin_name, err)
+	}
+	content, err := convertMarkdown(stdin_name, source)
+	return stdin_name, content, err
+}
+
+// Writes content as is, or as a full page (index.html) with the
+// styles and theme embedded and no scripts.
+func writeRendered(w io.Writer, filename string, content []byte, full bool) error {
+	if !full {
+		_, err := w.Write(content)
+		return err
+	}
+
+	styles, err := pageStyles()
+	if err != nil {
+		return err
+	}
+
+	return writePage(w, map[string]any{"Filename": filename,
+		"Theme":      serviceConfig.Theme,
+		"Standalone": true,
+		"Styles":     template.CSS(styles),
+		"Content":    template.HTML(content),
+	})
+}
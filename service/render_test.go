@@ -0,0 +1,59 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The embedded frontend, before tests replace it with the mock one.
+var frontendFS = f
+
+func TestRenderInputFromStdin(t *testing.T) {
+	name, content, err := renderInput("-", strings.NewReader("# Notes\n"))
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if name != stdin_name {
+		t.Errorf("got %s; want %s", name, stdin_name)
+	}
+	if !strings.Contains(string(content), "Notes</h1>") {
+		t.Errorf("got %s; want a heading", content)
+	}
+}
+
+func TestRenderInputMissingFile(t *testing.T) {
+	if _, _, err := renderInput("missing.md", nil); err == nil {
+		t.Error("got <nil>; want error on missing file")
+	}
+}
+
+func TestWriteRendered(t *testing.T) {
+	fsMutex.Lock()
+	defer fsMutex.Unlock()
+
+	// The full page is checked against the actual index.html.
+	f, fsPrefix = frontendFS, "frontend"
+	defer func() { fsPrefix = "mockfs" }()
+
+	content := []byte("<p>text</p>")
+
+	var fragment bytes.Buffer
+	writeRendered(&fragment, "doc.md", content, false)
+	if fragment.String() != string(content) {
+		t.Errorf("got %s; want %s", fragment.String(), content)
+	}
+
+	var page bytes.Buffer
+	if err := writeRendered(&page, "doc.md", content, true); err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	for _, want := range []string{"<title>doc.md</title>", "<style>", `<article class="markdown-body"><p>text</p></article>`} {
+		if !strings.Contains(page.String(), want) {
+			t.Errorf("got %s; want to contain %s", page.String(), want)
+		}
+	}
+	if strings.Contains(page.String(), "<script") {
+		t.Errorf("got %s; want no scripts", page.String())
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// The allowlists below mirror the sanitization GitHub applies to
+// rendered markdown, so that safe mode displays what GitHub would.
+var (
+	allowedTags = toSet(
+		"h1", "h2", "h3", "h4", "h5", "h6", "h7", "h8", "br", "b", "i",
+		"strong", "em", "a", "pre", "code", "img", "tt", "div", "ins",
+		"del", "sup", "sub", "p", "ol", "ul", "table", "thead", "tbody",
+		"tfoot", "blockquote", "dl", "dt", "dd", "kbd", "q", "samp", "var",
+		"hr", "ruby", "rt", "rp", "li", "tr", "td", "th", "s", "strike",
+		"summary", "details", "caption", "figure", "figcaption", "abbr",
+		"bdo", "cite", "dfn", "mark", "small", "span", "time", "wbr",
+		"picture", "source", "input",
+	)
+
+	// Tags removed along with everything inside them.
+	removedTags = toSet("script", "style")
+
+	// Attributes allowed on every tag.
+	allowedAttrs = toSet(
+		"abbr", "accept", "accept-charset", "accesskey", "action", "align",
+		"alt", "aria-describedby", "aria-hidden", "aria-label",
+		"aria-labelledby", "axis", "border", "cellpadding", "cellspacing",
+		"char", "charoff", "charset", "checked", "clear", "cols", "colspan",
+		"color", "compact", "coords", "datetime", "dir", "disabled",
+		"enctype", "for", "frame", "headers", "height", "hreflang", "hspace",
+		"ismap", "label", "lang", "maxlength", "media", "method", "multiple",
+		"name", "nohref", "noshade", "nowrap", "open", "progress", "prompt",
+		"readonly", "rel", "rev", "role", "rows", "rowspan", "rules", "scope",
+		"selected", "shape", "size", "span", "start", "summary", "tabindex",
+		"target", "title", "type", "usemap", "valign", "value", "vspace",
+		"width", "itemprop", "id",
+	)
+
+	// Attributes only allowed on specific tags.
+	allowedTagAttrs = map[string]map[string]bool{
+		"a":          toSet("href"),
+		"img":        toSet("src", "longdesc"),
+		"div":        toSet("itemscope", "itemtype"),
+		"blockquote": toSet("cite"),
+		"del":        toSet("cite"),
+		"ins":        toSet("cite"),
+		"q":          toSet("cite"),
+		"source":     toSet("srcset"),
+	}
+
+	// Classes are stripped on GitHub, except on the elements that
+	// the converter itself adds them to (code highlighting, footnotes
+	// and task lists). A class cannot run any script.
+	classTags = toSet("a", "code", "div", "li", "pre", "span", "ul")
+
+	// Protocols allowed in attributes holding URLs. An empty
+	// protocol is a relative URL.
+	allowedProtocols = map[string]map[string]bool{
+		"href":   toSet("", "http", "https", "mailto", "github-windows", "github-mac"),
+		"src":    toSet("", "http", "https"),
+		"srcset": toSet("", "http", "https"),
+		"cite":   toSet("", "http", "https"),
+	}
+
+	// Images embedded as data URLs (e.g. plots in notebooks) are
+	// allowed as the src of an <img>, but only in formats that cannot
+	// hold scripts (unlike SVG).
+	dataImageRegex = regexp.MustCompile(`^data:image/(png|jpeg|gif);base64,[A-Za-z0-9+/]*={0,2}$`)
+)
+
+func toSet(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+
+	return set
+}
+
+// Returns false if the URL held by an attribute uses a
+// protocol that is not allowed, e.g. "javascript:".
+func isAllowedURL(attr string, value string) bool {
+	protocols, ok := allowedProtocols[attr]
+	if !ok {
+		return true
+	}
+
+	// srcset holds a list of "url [descriptor]" candidates.
+	if attr == "srcset" {
+		for _, candidate := range strings.Split(value, ",") {
+			fields := strings.Fields(candidate)
+			if len(fields) > 0 && !isAllowedURL("src", fields[0]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+
+	return protocols[strings.ToLower(u.Scheme)]
+}
+
+func sanitizeAttrs(tag string, attrs []html.Attribute) []html.Attribute {
+	var sanitized []html.Attribute
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		allowed := allowedAttrs[key] || allowedTagAttrs[tag][key] ||
+			(key == "class" && classTags[tag])
+		dataImage := tag == "img" && key == "src" && dataImageRegex.MatchString(attr.Val)
+		if !allowed || attr.Namespace != "" || !(isAllowedURL(key, attr.Val) || dataImage) {
+			continue
+		}
+		sanitized = append(sanitized, html.Attribute{Key: key, Val: attr.Val})
+	}
+
+	// Only (read-only) task list checkboxes are kept.
+	if tag == "input" {
+		var checkbox, disabled bool
+		for _, attr := range sanitized {
+			checkbox = checkbox || (attr.Key == "type" && strings.ToLower(attr.Val) == "checkbox")
+			disabled = disabled || attr.Key == "disabled"
+		}
+		if !checkbox {
+			return nil
+		}
+		if !disabled {
+			sanitized = append(sanitized, html.Attribute{Key: "disabled"})
+		}
+	}
+
+	return sanitized
+}
+
+// Strips every tag, attribute and URL that is not allowed from
+// the converted HTML, keeping the text content of stripped tags
+// (except for removedTags). Comments are dropped as well.
+func sanitize(content []byte) []byte {
+	var out bytes.Buffer
+	tokenizer := html.NewTokenizer(bytes.NewReader(content))
+
+	// Name of the removed tag whose contents are being skipped.
+	skipping := ""
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// io.EOF or malformed input; either way, nothing more
+			// can be read.
+			return out.Bytes()
+		}
+
+		token := tokenizer.Token()
+		if skipping != "" {
+			if tokenType == html.EndTagToken && token.Data == skipping {
+				skipping = ""
+			}
+			continue
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			out.WriteString(token.String())
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if removedTags[token.Data] {
+				if tokenType == html.StartTagToken {
+					skipping = token.Data
+				}
+				continue
+			}
+			if !allowedTags[token.Data] {
+				continue
+			}
+
+			token.Attr = sanitizeAttrs(token.Data, token.Attr)
+			if token.Data == "input" && token.Attr == nil {
+				continue
+			}
+			out.WriteString(token.String())
+
+		case html.EndTagToken:
+			if allowedTags[token.Data] {
+				out.WriteString(token.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		// allowed as is
+		{`<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{`<a href="https://github.com">link</a>`, `<a href="https://github.com">link</a>`},
+		{`<a href="#section">link</a>`, `<a href="#section">link</a>`},
+		{`<img src="/assets/pikachu.png" alt="pikachu">`, `<img src="/assets/pikachu.png" alt="pikachu">`},
+		{`<img src="data:image/png;base64,iVBORw0KGgo=">`, `<img src="data:image/png;base64,iVBORw0KGgo=">`},
+		{`<details open><summary>More</summary>text</details>`, `<details open=""><summary>More</summary>text</details>`},
+		{`<pre class="chroma"><span class="k">func</span></pre>`, `<pre class="chroma"><span class="k">func</span></pre>`},
+
+		// removed with contents
+		{`<script>alert(1)</script><p>after</p>`, `<p>after</p>`},
+		{`<style>body { display: none; }</style>text`, `text`},
+
+		// tags stripped, contents kept
+		{`<iframe src="https://evil.com"></iframe>`, ``},
+		{`<form action="/x"><p>inside</p></form>`, `<p>inside</p>`},
+		{`<!-- comment --><p>text</p>`, `<p>text</p>`},
+
+		// attributes stripped
+		{`<p onclick="alert(1)" style="color: red">text</p>`, `<p>text</p>`},
+		{`<a href="javascript:alert(1)">link</a>`, `<a>link</a>`},
+		{`<a href=" JavaScript:alert(1)">link</a>`, `<a>link</a>`},
+		{`<img src="data:image/svg+xml;base64,AAAA">`, `<img>`},
+		{`<img src="data:text/html;base64,AAAA">`, `<img>`},
+		{`<a href="data:image/png;base64,AAAA">link</a>`, `<a>link</a>`},
+		{`<p class="markdown-body">text</p>`, `<p>text</p>`},
+
+		// only disabled checkboxes
+		{`<input type="text" value="x">`, ``},
+		{`<input checked type="checkbox">`, `<input checked="" type="checkbox" disabled="">`},
+
+		// text is escaped
+		{`<p>1 &lt; 2</p>`, `<p>1 &lt; 2</p>`},
+	}
+
+	for _, c := range cases {
+		got := string(sanitize([]byte(c.input)))
+		if got != c.want {
+			t.Errorf("sanitize(%q): got %q; want %q", c.input, got, c.want)
+		}
+	}
+}
+
+func TestConvertInSafeMode(t *testing.T) {
+	confMu.Lock()
+	savedSafe := serviceConfig.Safe
+	defer func() {
+		serviceConfig.Safe = savedSafe
+		confMu.Unlock()
+	}()
+	serviceConfig.Safe = true
+
+	file, _ := os.CreateTemp(".", "*")
+	file.WriteString("# Header\n\n<script>alert(1)</script>\n\n```go\nfunc main() {}\n```\n")
+	defer os.Remove(file.Name())
+
+	got, err := convertMarkdownToHTML(file.Name())
+	if err != nil {
+		t.Errorf("Should not return error. Got error \"%s\"", err)
+	}
+
+	if strings.Contains(string(got), "script") {
+		t.Errorf("got %s; want <script> removed", got)
+	}
+	if !strings.Contains(string(got), `class="chroma"`) {
+		t.Errorf("got %s; want highlighted code block with classes", got)
+	}
+	if strings.Contains(string(got), "style=") {
+		t.Errorf("got %s; want no inline styles", got)
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"strings"
 
 	"spamd/internal/browser"
 	"spamd/internal/options"
@@ -25,6 +28,9 @@ const (
 
 	// Everything is served locally.
 	protocol = "http://"
+
+	// Ports tried after a busy one (see listenFallback).
+	max_port_fallbacks = 10
 )
 
 // Set the configs for this service as a global,
@@ -33,6 +39,9 @@ var (
 	serviceConfig *config.ServiceConfig
 
 	watcher *fileWatcher
+
+	// Path of the unix socket listened at, if any.
+	socketPath string
 )
 
 func init() {
@@ -43,9 +52,40 @@ func init() {
 	}
 }
 
-func overrideConfig(theme string, codeBlockStyle string) {
-	serviceConfig.SetTheme(theme)
-	err := serviceConfig.SetCodeBlockTheme(codeBlockStyle)
+func overrideConfig(opts *options.Options) {
+	serviceConfig.SetTheme(opts.Theme)
+	if opts.Safe {
+		serviceConfig.Safe = true
+	}
+	if opts.Metrics {
+		serviceConfig.Metrics = true
+	}
+	if opts.PortFallback {
+		serviceConfig.PortFallback = true
+	}
+	if opts.TrustProxy {
+		serviceConfig.TrustProxy = true
+	}
+	if opts.Browser != "" {
+		serviceConfig.Browser = opts.Browser
+	}
+	err := serviceConfig.SetCodeBlockTheme(opts.CodeStyle)
+	if err != nil {
+		sys.ErrorAndExit(err.Error())
+	}
+	err = serviceConfig.SetRenderer(opts.Renderer)
+	if err != nil {
+		sys.ErrorAndExit(err.Error())
+	}
+	err = serviceConfig.SetListen(opts.Listen)
+	if err != nil {
+		sys.ErrorAndExit(err.Error())
+	}
+	err = serviceConfig.SetSocketMode(opts.SocketMode)
+	if err != nil {
+		sys.ErrorAndExit(err.Error())
+	}
+	err = serviceConfig.SetBasePath(opts.BasePath)
 	if err != nil {
 		sys.ErrorAndExit(err.Error())
 	}
@@ -66,35 +106,115 @@ func listen(port int) (net.Listener, error) {
 	return l, nil
 }
 
+// Listens at the first free port after port (trying up to
+// max_port_fallbacks of them), or at a random one.
+func listenFallback(port int) (net.Listener, error) {
+	for p := port + 1; p <= port+max_port_fallbacks && p <= 65535; p++ {
+		if l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", p)); err == nil {
+			return l, nil
+		}
+	}
+
+	return net.Listen("tcp", "localhost:0")
+}
+
+// Listens at the unix socket at path with permissions perm. A socket
+// left at path by a server that did not shut down is replaced.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Failed to start server at %s. The file exists and is not a socket.\n", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Failed to start server at %s. It is already in use.\n", path)
+		}
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start server at %s. %s\n", path, err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Failed to set the permissions of %s. %s\n", path, err)
+	}
+
+	socketPath = path
+	return l, nil
+}
+
 func start(l net.Listener) {
 	watcher = newFileWatcher(false)
 	mux := middleware.RegexpHandler{
 		AdditionalCheck: redirectIfNotMarkdown,
 	}
 	mux.HandleFunc(config.StylesPrefix, serveCSS)
+	mux.HandleFunc(config.ScriptsPrefix, serveJS)
 	mux.HandleFunc(config.ImageRegex, serveLocalImage)
 	mux.HandleFunc(config.RefreshPattern(), watcher.RefreshContent)
+	mux.HandleFunc("^"+config.StatusPrefix+"$", serveStatus)
+	mux.HandleFunc("^"+config.StopPrefix+"$", serveStop)
+	if serviceConfig.Metrics {
+		mux.HandleFunc("^"+config.MetricsPrefix+"$", serveMetrics)
+	}
 	mux.HandleFunc(allElse, serveHTML)
-	wrapper := middleware.NewLogger(&mux)
+	wrapper := middleware.NewProxyHeaders(middleware.NewLogger(middleware.NewSecurityHeaders(&mux, middleware.SecurityOptions{
+		FrameAncestors: serviceConfig.FrameAncestors,
+	})), middleware.ProxyOptions{
+		BasePath:     serviceConfig.BasePath,
+		TrustHeaders: serviceConfig.BehindProxy(),
+	})
 
 	// Must call this before main thread is blocked
 	// http.Serve.
 	watcher.harness.loops = endless_loop
 	watcher.Watch()
+	closeOnCtrlC()
 
 	log.Fatal(http.Serve(l, wrapper))
 }
 
+// Shuts the server down on Ctrl-C. Commands other than the server
+// (e.g. render, which writes to stdout) simply exit.
+func closeOnCtrlC() {
+	var interrupt = make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	go func() {
+		<-interrupt
+		// Close all websocket connections before exiting.
+		Shutdown()
+		os.Exit(1)
+	}()
+}
+
+// Messages go to stderr, as stdout may be piped (e.g. the URL printed
+// when previewing stdin).
 func Shutdown() {
-	fmt.Println("Shutting down server...")
-	watcher.CloseAllConn()
-	fmt.Println("Server has been shut down.")
+	sys.Eprintf("Shutting down server...\n")
+	unregisterInstance()
+	if socketPath != "" {
+		os.Remove(socketPath)
+	}
+	if watcher != nil {
+		watcher.CloseAllConn()
+	}
+
+	hits, misses, hitRate := cache.Stats()
+	sys.Eprintf("Render cache: %d hits, %d misses (%.0f%% hit rate).\n", hits, misses, hitRate*100)
+	sys.Eprintf("Server has been shut down.\n")
 }
 
 func redirectIfNotMarkdown(path string) bool {
-	if path == config.StylesPrefix {
+	if path == config.StylesPrefix || path == config.ScriptsPrefix ||
+		path == config.StatusPrefix || path == config.StopPrefix {
 		return true
 	}
+	if path == config.MetricsPrefix {
+		return serviceConfig.Metrics
+	}
 
 	var uri string
 	refreshRegex, _ := regexp.Compile(config.RefreshPattern())
@@ -105,14 +225,36 @@ func redirectIfNotMarkdown(path string) bool {
 		uri = path
 	}
 
+	p := strings.TrimPrefix(uri, "/")
 	imageRegex, _ := regexp.Compile(config.ImageRegex)
-	cwd, _ := os.Getwd()
-	// Skip non-markdown and non-image files.
-	if !sys.IsFileWithExt(cwd+uri, ".md") && !imageRegex.Match([]byte(uri)) {
+	if imageRegex.Match([]byte(uri)) {
+		if isRevisionPath(p) {
+			_, _, ok := parseRevisionPath(p)
+			return ok
+		}
+		return inWorkingDir(p)
+	}
+
+	if isStdinPath(p) {
+		return true
+	}
+	if !isRevisionPath(p) {
+		if !inWorkingDir(p) || !sys.IsFile(p) {
+			return false
+		}
+		if isMarkup(p) {
+			return true
+		}
+	}
+
+	// Other than documents, only text files (displayed as code)
+	// are served. Files in revisions are read from the repository.
+	data, err := readSource(p)
+	if err != nil {
 		return false
 	}
 
-	return true
+	return isMarkup(p) || isText(data)
 }
 
 func printAdditionalInfo(address string) {
@@ -128,16 +270,78 @@ func Run(opts *options.Options, version string) {
 		os.Exit(0)
 	}
 
-	overrideConfig(opts.Theme, opts.CodeStyle)
+	overrideConfig(opts)
+	metrics.version = version
+
+	if !opts.Stdin && !opts.New {
+		if running := instanceIn(workingDir()); running != nil {
+			browser.MassOpen(browser.New(serviceConfig.Browser), running.Address, opts.NoBrowser, isMarkup)
+			if strings.HasPrefix(running.Address, config.UNIX_PREFIX) {
+				// No browser is opened for servers at unix sockets.
+				fmt.Printf("spamd is already running in this directory at %s (pid %d).\nRun with -new to start another server.\n", running.Address, running.PID)
+			} else {
+				fmt.Printf("spamd is already running in this directory at %s (pid %d), so the markdowns were opened there.\nRun with -new to start another server.\n", running.Address, running.PID)
+			}
+			return
+		}
+	}
 
-	l, err := listen(opts.Port)
+	var l net.Listener
+	var baseUrl string
+	var err error
+	if serviceConfig.Listen != "" {
+		l, err = listenUnix(strings.TrimPrefix(serviceConfig.Listen, config.UNIX_PREFIX), serviceConfig.SocketPermissions())
+		baseUrl = serviceConfig.Listen
+	} else {
+		port := opts.Port
+		if port == 0 {
+			port = serviceConfig.Port
+		}
+
+		l, err = listen(port)
+		if err != nil && port != 0 {
+			st := spamdAt(port)
+			if st != nil && st.Dir == workingDir() && !opts.Stdin && !opts.New {
+				address := fmt.Sprintf("%slocalhost:%d", protocol, port)
+				browser.MassOpen(browser.New(serviceConfig.Browser), address, opts.NoBrowser, isMarkup)
+				fmt.Printf("spamd is already running in this directory at %s, so the markdowns were opened there.\n", address)
+				return
+			}
+
+			if serviceConfig.PortFallback {
+				l, err = listenFallback(port)
+				if err == nil {
+					busy := "another program"
+					if st != nil {
+						busy = "spamd in " + st.Dir
+					}
+					slog.Warn(fmt.Sprintf("Port %d is used by %s. Using another port instead.", port, busy), "address", l.Addr().String())
+				}
+			} else {
+				err = fmt.Errorf("%sSet \"port_fallback\" (or -port-fallback) to use the next free port instead.", err)
+			}
+		}
+		if err == nil {
+			baseUrl = protocol + l.Addr().String()
+		}
+	}
 	if err != nil {
 		sys.ErrorAndExit(err.Error())
 	}
-	baseUrl := protocol + l.Addr().String()
-
-	browser.MassOpen(baseUrl, opts.NoBrowser)
+	registerInstance(baseUrl, version)
 
-	printAdditionalInfo(baseUrl)
+	if opts.Stdin {
+		previewStdin(os.Stdin)
+		url := baseUrl + "/" + stdin_path
+		if !opts.NoBrowser && serviceConfig.Listen == "" {
+			browser.OpenOrReport(browser.New(serviceConfig.Browser), url)
+		}
+		fmt.Printf("Previewing stdin at %s\n", url)
+	} else {
+		browser.MassOpen(browser.New(serviceConfig.Browser), baseUrl, opts.NoBrowser, isMarkup)
+		if serviceConfig.Listen == "" {
+			printAdditionalInfo(baseUrl)
+		}
+	}
 	start(l)
 }
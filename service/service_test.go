@@ -1,6 +1,9 @@
 package service
 
 import (
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strconv"
@@ -8,6 +11,7 @@ import (
 	"sync"
 	"testing"
 
+	"spamd/internal/options"
 	"spamd/service/config"
 )
 
@@ -32,6 +36,81 @@ func TestListenReturnsErrOnInvalidPort(t *testing.T) {
 	}
 }
 
+func TestListenFallback(t *testing.T) {
+	busy, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	if _, err := listen(port); err == nil {
+		t.Fatalf("Should return error if port %d is busy.", port)
+	}
+
+	l, err := listenFallback(port)
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	defer l.Close()
+	if got := l.Addr().(*net.TCPAddr).Port; got == port {
+		t.Errorf("got port %d; want another port", got)
+	}
+}
+
+func TestSpamdAt(t *testing.T) {
+	watcher = newFileWatcher(true)
+	metrics.version = "test"
+	defer func() { metrics.version = "" }()
+
+	s := httptest.NewServer(http.HandlerFunc(serveStatus))
+	defer s.Close()
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+
+	st := spamdAt(s.Listener.Addr().(*net.TCPAddr).Port)
+	if st == nil || st.Dir != workingDir() {
+		t.Errorf("got %v; want spamd in %s", st, workingDir())
+	}
+	if st := spamdAt(other.Listener.Addr().(*net.TCPAddr).Port); st != nil {
+		t.Errorf("got %v; want <nil> for another program", st)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	socket := path.Join(t.TempDir(), "spamd.sock")
+	defer func() { socketPath = "" }()
+
+	l, err := listenUnix(socket, 0660)
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	info, _ := os.Stat(socket)
+	if perm := info.Mode().Perm(); perm != 0660 {
+		t.Errorf("got %o; want 660", perm)
+	}
+
+	// The socket is in use.
+	if _, err := listenUnix(socket, 0660); err == nil {
+		t.Error("got <nil>; want error on socket in use")
+	}
+
+	// A stale socket is replaced.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = listenUnix(socket, 0600)
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	l.Close()
+
+	file := path.Join(t.TempDir(), "notes.md")
+	os.WriteFile(file, nil, 0644)
+	if _, err := listenUnix(file, 0600); err == nil {
+		t.Error("got <nil>; want error on existing file")
+	}
+}
+
 func TestListenOnConfigPortOnZeroPort(t *testing.T) {
 	confMu.Lock()
 	// Should default to serviceConfig port (if non-zero).
@@ -76,6 +155,18 @@ func TestValidRedirects(t *testing.T) {
 	}
 }
 
+func TestRedirectOtherMarkdownExtensions(t *testing.T) {
+	for _, pattern := range []string{"*.MD", "*.markdown", "*.mdx"} {
+		file, _ := os.CreateTemp(".", pattern)
+		defer os.Remove(file.Name())
+
+		uri := "/" + path.Base(file.Name())
+		if got := redirectIfNotMarkdown(uri); got != true {
+			t.Errorf("redirectIfNotMarkdown(\"%s\") should return true.", uri)
+		}
+	}
+}
+
 func TestRedirectOnNoSuchFile(t *testing.T) {
 	uri := "/file-no-exists.md"
 	got := redirectIfNotMarkdown(uri)
@@ -88,17 +179,27 @@ func TestOverrideTheme(t *testing.T) {
 	confMu.Lock()
 	savedTheme := serviceConfig.Theme
 	savedCodeStyle := serviceConfig.CodeBlockTheme
+	savedSafe := serviceConfig.Safe
+	savedRenderer := serviceConfig.Renderer
 	defer func() {
 		// reset configs
 		serviceConfig.SetTheme(savedTheme)
 		serviceConfig.SetCodeBlockTheme(savedCodeStyle)
+		serviceConfig.Safe = savedSafe
+		serviceConfig.Renderer = savedRenderer
 		confMu.Unlock()
 	}()
 
 	wantTheme := "dark"
 	wantCodestyle := "xcode"
-
-	overrideConfig(wantTheme, wantCodestyle)
+	wantRenderer := "github"
+
+	overrideConfig(&options.Options{
+		Theme:     wantTheme,
+		CodeStyle: wantCodestyle,
+		Safe:      true,
+		Renderer:  wantRenderer,
+	})
 	if serviceConfig.Theme != wantTheme {
 		t.Errorf("OverrideConfig() : want %s, got %s\n", wantTheme, serviceConfig.Theme)
 	}
@@ -107,4 +208,12 @@ func TestOverrideTheme(t *testing.T) {
 		t.Errorf("OverrideConfig() : want %s, got %s\n", wantCodestyle, serviceConfig.CodeBlockTheme)
 	}
 
+	if !serviceConfig.Safe {
+		t.Error("OverrideConfig() : want safe mode on, got off")
+	}
+
+	if serviceConfig.Renderer != wantRenderer {
+		t.Errorf("OverrideConfig() : want %s, got %s\n", wantRenderer, serviceConfig.Renderer)
+	}
+
 }
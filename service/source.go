@@ -0,0 +1,129 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"spamd/internal/git"
+	"spamd/internal/sys"
+)
+
+const (
+	// Paths starting with this prefix, e.g. "@main/docs/api.md" or
+	// "@<sha>/README.md", are read from a revision of the local git
+	// repository instead of the working tree.
+	revision_prefix = "@"
+)
+
+// Splits a revision-qualified path (without the leading '/') into its
+// revision and the path of the file in it.
+//
+// Revisions may contain '/' (e.g. "@feature/x/README.md"), so the
+// shortest leading run of segments naming a valid revision is used.
+// Paths with ".." segments after it are refused.
+func parseRevisionPath(p string) (string, string, bool) {
+	if !strings.HasPrefix(p, revision_prefix) {
+		return "", "", false
+	}
+
+	segments := strings.Split(p[len(revision_prefix):], "/")
+	for i := 1; i < len(segments); i++ {
+		// Files in revisions are read relative to the current directory
+		// (like in the working tree), which they must not leave.
+		if slices.Contains(segments[i:], "..") {
+			return "", "", false
+		}
+
+		ref := strings.Join(segments[:i], "/")
+		if _, err := git.ResolveRef(ref); err == nil {
+			return ref, strings.Join(segments[i:], "/"), true
+		}
+	}
+
+	return "", "", false
+}
+
+func isRevisionPath(p string) bool {
+	return strings.HasPrefix(p, revision_prefix)
+}
+
+// Returns true if the file at p (relative to the current directory)
+// exists inside the current directory, once ".." segments and symlinks
+// are resolved. Requests for paths like "/../../etc/passwd" reach the
+// handlers uncleaned, so other files must be refused.
+func inWorkingDir(p string) bool {
+	cwd := workingDir()
+	if cwd == "" {
+		return false
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(cwd, filepath.FromSlash(p)))
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(cwd, resolved)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Reads a file from the working tree, or from a revision
+// if p is a revision-qualified path (or stdin, see stdin.go).
+func readSource(p string) ([]byte, error) {
+	if isStdinPath(p) {
+		return stdin.Bytes(), nil
+	}
+	if !isRevisionPath(p) {
+		return os.ReadFile(p)
+	}
+
+	ref, file, ok := parseRevisionPath(p)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+
+	return git.Show(ref, file)
+}
+
+// Returns the path (relative to the current directory) to the file
+// referred to by uri (the part of the URL after the route prefix)
+// along with its last modified time.
+//
+// Files in revisions never change, so they have a zero time.
+func resolveSource(uri string) (string, time.Time, error) {
+	p := strings.TrimPrefix(uri, "/")
+	if isStdinPath(p) {
+		return p, stdin.Modtime(), nil
+	}
+	if isRevisionPath(p) {
+		if _, err := readSource(p); err != nil {
+			return "", time.Time{}, err
+		}
+		return p, time.Time{}, nil
+	}
+
+	p, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	modtime, err := sys.Modtime(p)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return p, modtime, nil
+}
+
+// Returns the last modified time of the file at p (as returned by
+// resolveSource).
+func sourceModtime(p string) (time.Time, error) {
+	if isStdinPath(p) {
+		return stdin.Modtime(), nil
+	}
+	return sys.Modtime(p)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"spamd/internal/git"
+)
+
+// These tests read files of this package from the HEAD of the
+// repository containing it.
+func skipIfNotRepository(t *testing.T) {
+	if _, err := git.ResolveRef("HEAD"); err != nil {
+		t.Skipf("Not run inside a git repository. %s", err)
+	}
+}
+
+func TestParseRevisionPath(t *testing.T) {
+	skipIfNotRepository(t)
+
+	ref, file, ok := parseRevisionPath("@HEAD/frontend/index.html")
+	if !ok || ref != "HEAD" || file != "frontend/index.html" {
+		t.Errorf("got (%s, %s, %t); want (HEAD, frontend/index.html, true)", ref, file, ok)
+	}
+
+	for _, p := range []string{"README.md", "@no-such-revision/README.md", "@HEAD"} {
+		if _, _, ok := parseRevisionPath(p); ok {
+			t.Errorf("parseRevisionPath(%q) should return false.", p)
+		}
+	}
+}
+
+func TestReadSourceFromRevision(t *testing.T) {
+	skipIfNotRepository(t)
+
+	got, err := readSource("@HEAD/parser.go")
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if !bytes.HasPrefix(got, []byte("package service")) {
+		t.Errorf("got %s; want contents of parser.go", got)
+	}
+
+	_, err = readSource("@no-such-revision/parser.go")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v; want os.ErrNotExist", err)
+	}
+}
+
+func TestResolveSource(t *testing.T) {
+	skipIfNotRepository(t)
+
+	file, _ := os.CreateTemp(".", "*.md")
+	defer os.Remove(file.Name())
+
+	// Working tree.
+	p, modtime, err := resolveSource("/" + file.Name()[2:])
+	if err != nil || p != file.Name()[2:] || modtime.IsZero() {
+		t.Errorf("got (%s, %s, %v); want (%s, <modtime>, <nil>)", p, modtime, err, file.Name()[2:])
+	}
+
+	// Revision.
+	p, modtime, err = resolveSource("/@HEAD/parser.go")
+	if err != nil || p != "@HEAD/parser.go" || modtime != (time.Time{}) {
+		t.Errorf("got (%s, %s, %v); want (@HEAD/parser.go, <zero>, <nil>)", p, modtime, err)
+	}
+
+	// Untracked file is not in the revision.
+	if _, _, err = resolveSource("/@HEAD/" + file.Name()[2:]); err == nil {
+		t.Error("got <nil>; want error for file missing from revision")
+	}
+}
+
+func TestRedirectRevisionPaths(t *testing.T) {
+	skipIfNotRepository(t)
+
+	if got := redirectIfNotMarkdown("/@HEAD/no-such-file.md"); got != false {
+		t.Error("redirectIfNotMarkdown(\"/@HEAD/no-such-file.md\") should return false.")
+	}
+	// Displayed as code.
+	if got := redirectIfNotMarkdown("/@HEAD/parser.go"); got != true {
+		t.Error("redirectIfNotMarkdown(\"/@HEAD/parser.go\") should return true.")
+	}
+
+	// Files above the current directory are refused, like in the
+	// working tree.
+	for _, uri := range []string{"/@HEAD/../README.md", "/@HEAD/docs/../../README.md", "/@HEAD/../frontend/x.png"} {
+		if redirectIfNotMarkdown(uri) {
+			t.Errorf("redirectIfNotMarkdown(\"%s\") should return false.", uri)
+		}
+	}
+	if _, err := readSource("@HEAD/../README.md"); err == nil {
+		t.Error("got <nil>; want error reading above the current directory")
+	}
+}
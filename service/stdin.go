@@ -0,0 +1,85 @@
+package service
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// Markdown piped into "spamd -" is served at this path (shadowing
+	// any file of the same name in the current directory).
+	stdin_path = "stdin.md"
+
+	stdin_buffer_size = 32 * 1024
+)
+
+// Markdown read from stdin, which keeps growing for as long as stdin
+// is open (e.g. when piped from "tail -f").
+type stdinSource struct {
+	mu      sync.Mutex
+	data    []byte
+	modtime time.Time
+}
+
+// Set only when previewing stdin.
+var stdin *stdinSource
+
+func newStdinSource() *stdinSource {
+	return &stdinSource{modtime: time.Now()}
+}
+
+func isStdinPath(p string) bool {
+	return stdin != nil && p == stdin_path
+}
+
+// Appends everything read from r until EOF. Each chunk read updates
+// the modified time, so that the watcher sends the new render.
+func (s *stdinSource) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	buf := make([]byte, stdin_buffer_size)
+	for {
+		read, err := r.Read(buf)
+		if read > 0 {
+			s.mu.Lock()
+			s.data = append(s.data, buf[:read]...)
+			s.modtime = time.Now()
+			s.mu.Unlock()
+			n += int64(read)
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+func (s *stdinSource) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]byte(nil), s.data...)
+}
+
+func (s *stdinSource) Modtime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.modtime
+}
+
+// Starts reading stdin into the markdown served at stdin_path.
+func previewStdin(r io.Reader) {
+	source := newStdinSource()
+	stdin = source
+	go func() {
+		if _, err := source.ReadFrom(r); err != nil {
+			slog.Error("Failed to read stdin", "error", err)
+			return
+		}
+		slog.Info("Reached the end of stdin")
+	}()
+}
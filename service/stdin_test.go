@@ -0,0 +1,69 @@
+package service
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadStdin(t *testing.T) {
+	source := newStdinSource()
+	before := source.Modtime()
+	time.Sleep(time.Millisecond)
+
+	source.ReadFrom(strings.NewReader("# Title\n"))
+	if got := string(source.Bytes()); got != "# Title\n" {
+		t.Errorf("got %q; want %q", got, "# Title\n")
+	}
+	if !source.Modtime().After(before) {
+		t.Errorf("got %s; want modified time after %s", source.Modtime(), before)
+	}
+}
+
+func TestTriggerWriteOnStdin(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	previewStdin(r)
+	// Runs after the watcher has stopped reading stdin.
+	defer func() { stdin = nil }()
+
+	filepath, modtime, err := resolveSource("/" + stdin_path)
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+
+	watcher := newFileWatcher(true)
+	watcher.files[filepath] = &connCluster{
+		Lastmodifed: modtime,
+		conns: []*conn{
+			{
+				Ch: make(chan string),
+			},
+		},
+	}
+
+	watcher.harness.useWaitGroup = true
+	watcher.watchInv = 0
+	watcher.harness.wg.Add(1)
+	watcher.Watch()
+	defer watcher.stopWatching()
+	watcher.harness.wg.Wait()
+
+	time.Sleep(30 * time.Millisecond)
+	w.Write([]byte("Streamed paragraph."))
+
+	for _, conn := range watcher.files[filepath].conns {
+		if msg := <-conn.Ch; msg != write_success {
+			t.Errorf("got %s; want %s", msg, write_success)
+		}
+	}
+
+	content, err := renderFile(filepath)
+	if err != nil {
+		t.Fatalf("Should not return error. Got error \"%s\"", err)
+	}
+	if !strings.Contains(string(content), "<p>Streamed paragraph.</p>") {
+		t.Errorf("got %s; want the streamed paragraph", content)
+	}
+}
@@ -1,15 +1,17 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
 	"spamd/internal/sys"
 	"spamd/service/config"
+	"spamd/service/middleware"
 
 	"github.com/gorilla/websocket"
 )
@@ -21,8 +23,28 @@ const (
 	write_success = "success"
 	error_read    = "error_read"
 	close_conn    = "close"
+
+	// Types of messages sent to the browser.
+	content_message       = "content"
+	reload_images_message = "reload_images"
+	error_message         = "error"
 )
 
+// Every message written to the websocket is encoded as JSON
+// so that the browser can tell page content apart from
+// other notifications.
+type message struct {
+	Type string `json:"type"`
+	// Rendered HTML of the whole document.
+	Html string `json:"html,omitempty"`
+	// Server paths of local images to be reloaded.
+	Images []string `json:"images,omitempty"`
+	// Why the file could not be read or rendered. The page
+	// sent before (if any) is kept.
+	Error string `json:"error,omitempty"`
+	File  string `json:"file,omitempty"`
+}
+
 // This struct is used to store all information used during testing.
 type testHarness struct {
 	// Used in testing to control number of iterations of main listener loop.
@@ -32,6 +54,10 @@ type testHarness struct {
 	wg *sync.WaitGroup
 	// Determine whether to use wg or not.
 	useWaitGroup bool
+	// Closed to stop the Watch() loop, which closes stopped once it
+	// has returned (see stopWatching).
+	stop    chan struct{}
+	stopped chan struct{}
 }
 
 func newTestHarness() testHarness {
@@ -39,6 +65,8 @@ func newTestHarness() testHarness {
 		loops:        1,
 		wg:           new(sync.WaitGroup),
 		useWaitGroup: false,
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
 	}
 }
 
@@ -61,8 +89,26 @@ type conn struct {
 	// channel help facilitate that.
 	Ch chan string
 
+	// Receives the server paths of local images (referenced by
+	// this connection's file) that were modified (see ReloadImages).
+	ImagesCh chan []string
+
 	// gorilla/websocket
 	Conn websocketConn
+
+	// If set, the rendered diff against this revision is sent
+	// instead of the rendered file.
+	DiffRef string
+
+	// ID of the request that opened this connection (see
+	// middleware.RequestID), to tell connections apart in logs.
+	ID uint64
+
+	// Local images referenced in the last page sent through
+	// this connection. Guarded by its own lock since the watcher
+	// reads it while holding the fileWatcher lock.
+	images   []string
+	imagesMu sync.Mutex
 }
 
 func (c *conn) Trigger(event string) error {
@@ -77,8 +123,9 @@ func (c *conn) Trigger(event string) error {
 
 func newConn(c websocketConn) *conn {
 	return &conn{
-		Ch:   make(chan string),
-		Conn: c,
+		Ch:       make(chan string),
+		ImagesCh: make(chan []string, 1),
+		Conn:     c,
 	}
 }
 
@@ -86,19 +133,78 @@ func (c *conn) SendText(content []byte) error {
 	return c.Conn.WriteMessage(websocket.TextMessage, content)
 }
 
+func (c *conn) SendMessage(msg message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.SendText(data)
+}
+
 func (c *conn) SendConvertedMarkdownFromFile(filepath string) error {
-	content, err := convertMarkdownToHTML(filepath)
+	var content []byte
+	var err error
+	start := time.Now()
+	if c.DiffRef != "" {
+		content, err = renderDiff(filepath, c.DiffRef)
+	} else {
+		content, err = renderFile(filepath)
+	}
+	metrics.ObserveRender(filepath, time.Since(start), err)
 	if err != nil {
 		return err
 	}
 
-	err = c.SendText(content)
+	err = c.SendMessage(message{Type: content_message, Html: string(content)})
 	if err != nil {
 		return err
 	}
+
+	c.SetImages(localImages(filepath, content))
 	return nil
 }
 
+// Sends err (from reading or rendering filepath) to be shown over
+// the last page sent.
+func (c *conn) SendError(filepath string, err error) error {
+	return c.SendMessage(message{Type: error_message, Error: err.Error(), File: filepath})
+}
+
+// Queues images to be reloaded without blocking, since it is called
+// by the watcher while holding its lock, and the tab may have closed
+// (so that nothing receives from ImagesCh anymore). Images not yet
+// received are merged into the new ones. The watcher is the only
+// sender, so the (single) buffer slot is free once they are taken.
+func (c *conn) ReloadImages(images []string) {
+	for {
+		select {
+		case c.ImagesCh <- images:
+			return
+		case pending := <-c.ImagesCh:
+			for _, img := range pending {
+				if !slices.Contains(images, img) {
+					images = append(images, img)
+				}
+			}
+		}
+	}
+}
+
+func (c *conn) SetImages(images []string) {
+	c.imagesMu.Lock()
+	defer c.imagesMu.Unlock()
+
+	c.images = images
+}
+
+func (c *conn) Images() []string {
+	c.imagesMu.Lock()
+	defer c.imagesMu.Unlock()
+
+	return c.images
+}
+
 func (c *conn) OnReadConn(event string) (int, []byte, error) {
 	ty, data, err := c.Conn.ReadMessage()
 
@@ -114,6 +220,10 @@ func (c *conn) OnReadConn(event string) (int, []byte, error) {
 type connCluster struct {
 	Lastmodifed time.Time
 	conns       []*conn
+
+	// Last modified time of each local image referenced by
+	// the file. Images missing from disk have a zero time.
+	Images map[string]time.Time
 }
 
 type fileWatcher struct {
@@ -137,6 +247,7 @@ func (f *fileWatcher) AddConn(filepath string, modtime time.Time, c websocketCon
 	defer f.lock.Unlock()
 
 	newConn := newConn(c)
+	metrics.ConnOpened()
 	cluster, ok := f.files[filepath]
 	if !ok {
 		f.files[filepath] = &connCluster{
@@ -180,8 +291,10 @@ func (f *fileWatcher) DeleteConn(filepath string, targetConn *conn) error {
 
 	updatedConnections := append(cluster.conns[:index], cluster.conns[index+1:]...)
 	if len(updatedConnections) == 0 {
-		// No more connections to this file, so drop key-value pair.
+		// No more connections to this file, so drop key-value pair
+		// along with what is cached for it.
 		delete(f.files, filepath)
+		cache.Evict(filepath)
 	} else {
 		cluster.conns = updatedConnections
 	}
@@ -212,14 +325,8 @@ func (f *fileWatcher) CloseAllConn() {
 
 func (f *fileWatcher) RefreshContent(w http.ResponseWriter, r *http.Request) {
 	// Get the path relative to the directory where the tool is run.
-	// '+1' to skip the leading '/'.
 	uri := r.URL.Path
-	filepath, err := filepath.EvalSymlinks(uri[len(config.RefreshPrefix)+1:])
-	if err != nil {
-		return
-	}
-
-	modtime, err := sys.Modtime(filepath)
+	filepath, modtime, err := resolveSource(uri[len(config.RefreshPrefix):])
 	if err != nil {
 		return
 	}
@@ -230,11 +337,23 @@ func (f *fileWatcher) RefreshContent(w http.ResponseWriter, r *http.Request) {
 	// Add mapping storing the connection.
 	conn := f.AddConn(filepath, modtime, wsConn)
 	defer f.DeleteConn(filepath, conn) // Close() will be called here
+	conn.ID = middleware.RequestID(r)
+	logger := slog.With("conn", conn.ID, "file", filepath)
+	logger.Debug("Opened tab")
+
+	// Only markdowns in the working copy can be diffed.
+	if refs, ok := r.URL.Query()[diff_param]; ok && !isRevisionPath(filepath) && !isStdinPath(filepath) && isMarkup(filepath) {
+		conn.DiffRef = default_diff_ref
+		if len(refs[0]) > 0 {
+			conn.DiffRef = refs[0]
+		}
+	}
 
-	// Read first page
+	// Read first page. On errors, the connection is kept open so
+	// that the page is sent once the file is fixed.
 	if err := conn.SendConvertedMarkdownFromFile(filepath); err != nil {
-		log.Fatalln(err)
-		return
+		logger.Error("Failed to render", "error", err)
+		conn.SendError(filepath, err)
 	}
 
 	// Listen for close connection.
@@ -261,21 +380,29 @@ func (f *fileWatcher) RefreshContent(w http.ResponseWriter, r *http.Request) {
 			if msg == error_read {
 				// During such error, file will be deleted from
 				// trackFiles & messageChannels during Watch().
-				log.Printf("Error watching file. %s is either deleted/renamed/moved.\n", filepath)
+				logger.Warn("Error watching file. It is either deleted/renamed/moved.")
 				return
 			}
 
 			if msg == write_success {
 				if err := conn.SendConvertedMarkdownFromFile(filepath); err != nil {
-					log.Fatalln(err)
+					logger.Error("Failed to render", "error", err)
+					conn.SendError(filepath, err)
 					continue
 				}
+				logger.Debug("Sent page")
 			}
 
 			if msg == close_conn {
-				log.Printf("Closed tab for %s\n", filepath)
+				logger.Info("Closed tab")
 				return
 			}
+
+		case images := <-conn.ImagesCh:
+			if err := conn.SendMessage(message{Type: reload_images_message, Images: images}); err != nil {
+				logger.Error("Failed to reload images", "error", err)
+				continue
+			}
 		}
 	}
 }
@@ -286,16 +413,29 @@ func (f *fileWatcher) Watch() {
 		if f.harness.useWaitGroup {
 			f.harness.wg.Done()
 		}
+		if f.harness.stopped != nil {
+			defer close(f.harness.stopped)
+		}
 
 		for {
-			time.Sleep(f.watchInv)
+			// The harness channels are nil (never ready) outside tests.
+			select {
+			case <-f.harness.stop:
+				return
+			case <-time.After(f.watchInv):
+			}
 
 			func() {
 				f.lock.Lock()
 				defer f.lock.Unlock()
 
 				for filepath := range f.files {
-					newModtime, err := sys.Modtime(filepath)
+					// Files (and images) in revisions never change.
+					if isRevisionPath(filepath) {
+						continue
+					}
+
+					newModtime, err := sourceModtime(filepath)
 					if err != nil {
 						cluster := f.files[filepath]
 						// Signal each connection to this file that the
@@ -304,27 +444,92 @@ func (f *fileWatcher) Watch() {
 							conn.Trigger(error_read)
 						}
 						f.CloseClusterConn(filepath)
-						log.Printf("Watch(): %s cannot be found\n", filepath)
+						cache.Invalidate(filepath)
+						slog.Warn("File cannot be found", "file", filepath)
 						continue
 					}
 
 					cluster := f.files[filepath]
 					if cluster.Lastmodifed != newModtime {
-						fmt.Printf("%s was modified at: %s\n", filepath, time.Now().Local())
+						slog.Info("File modified", "file", filepath)
 
 						// Update Lastmodifed time, otherwise it will be different each time.
 						cluster.Lastmodifed = newModtime
+						cache.Invalidate(filepath)
 
 						for _, conn := range cluster.conns {
 							conn.Trigger(write_success)
 						}
 					}
+
+					f.checkImages(cluster)
 				}
 			}()
 		}
 	}()
 }
 
+// Stops the loop started by Watch() and waits for it to return.
+// Only used in tests, with the harness.
+func (f *fileWatcher) stopWatching() {
+	close(f.harness.stop)
+	<-f.harness.stopped
+}
+
+// Notifies each connection in cluster of the local images (that
+// it has referenced) which were modified since the last check.
+//
+// Must be called while holding the lock.
+func (f *fileWatcher) checkImages(cluster *connCluster) {
+	if cluster.Images == nil {
+		cluster.Images = make(map[string]time.Time)
+	}
+
+	referenced := make(map[string]bool)
+	changed := make(map[string]bool)
+	for _, conn := range cluster.conns {
+		for _, img := range conn.Images() {
+			if referenced[img] {
+				continue
+			}
+			referenced[img] = true
+
+			// Missing images are recorded with a zero time, so that
+			// they are reloaded once they appear on disk.
+			newModtime, _ := sys.Modtime(img)
+			lastModtime, seen := cluster.Images[img]
+			if seen && lastModtime != newModtime {
+				slog.Info("Image modified", "file", img)
+				changed[img] = true
+			}
+			cluster.Images[img] = newModtime
+		}
+	}
+
+	// Stop tracking images no longer referenced by any connection.
+	for img := range cluster.Images {
+		if !referenced[img] {
+			delete(cluster.Images, img)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, conn := range cluster.conns {
+		var images []string
+		for _, img := range conn.Images() {
+			if changed[img] {
+				images = append(images, "/"+img)
+			}
+		}
+		if len(images) > 0 {
+			conn.ReloadImages(images)
+		}
+	}
+}
+
 func newFileWatcher(useHarness bool) *fileWatcher {
 	watcher := &fileWatcher{
 		files:    make(map[string]*connCluster),
@@ -103,7 +103,10 @@ func TestDeleteConnection(t *testing.T) {
 		t.Error("got <nil>; want error.")
 	}
 
-	// 2. delete existing conn
+	// 2. delete existing conn, which drops what is cached for the file
+	cache.Convert(file, []byte("# Header"), func(source []byte) ([]byte, error) {
+		return source, nil
+	})
 	watcher.files[file] = &connCluster{
 		conns: []*conn{
 			targetConn,
@@ -117,6 +120,12 @@ func TestDeleteConnection(t *testing.T) {
 	if len(watcher.files) != 0 {
 		t.Errorf("got %d; want 0.", len(watcher.files))
 	}
+	cache.lock.Lock()
+	_, cached := cache.entries[file]
+	cache.lock.Unlock()
+	if cached {
+		t.Errorf("got %s cached; want it evicted", file)
+	}
 }
 
 func TestMultipleAddConn(t *testing.T) {
@@ -196,7 +205,7 @@ func TestCloseConnection(t *testing.T) {
 }
 
 func TestGetFirstPageOnConnect(t *testing.T) {
-	file, _ := os.CreateTemp(".", "*")
+	file, _ := os.CreateTemp(".", "*.md")
 	file.WriteString(`# First Page
 
 An example tranformation of markdown contents into
@@ -217,18 +226,60 @@ actual HTML.
 	}
 
 	// Read from websocket.
-	_, got, err := ws.ReadMessage()
-	if err != nil {
+	var msg message
+	if err := ws.ReadJSON(&msg); err != nil {
 		t.Errorf("Error reading websocket connection: %s", err)
 	}
 
+	if msg.Type != content_message {
+		t.Errorf("got %s; want %s", msg.Type, content_message)
+	}
+
 	want := `<h1 id="first-page">First Page</h1>
 <p>An example tranformation of markdown contents into
 actual HTML.</p>
 <h2 id="xyz">XYZ</h2>
 `
-	if string(got) != want {
-		t.Errorf("got %s; want %s", string(got), want)
+	if msg.Html != want {
+		t.Errorf("got %s; want %s", msg.Html, want)
+	}
+}
+
+func TestSendErrorThenPageOnFix(t *testing.T) {
+	file, _ := os.CreateTemp(".", "*.ipynb")
+	file.WriteString(`{"cells": [`)
+	defer os.Remove(file.Name())
+	filepath := file.Name()[2:]
+
+	watcher := newFileWatcher(true)
+	resourceUri := config.RefreshPrefix + file.Name()[1:]
+
+	s, ws, err := createMockWsConn(resourceUri, watcher.RefreshContent)
+	defer s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg message
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Errorf("Error reading websocket connection: %s", err)
+	}
+	if msg.Type != error_message || msg.File != filepath || msg.Error == "" {
+		t.Errorf("got %+v; want an error message for %s", msg, filepath)
+	}
+
+	// The connection is kept open, so the fixed file is sent.
+	os.WriteFile(filepath, []byte(`{"nbformat": 4, "cells": []}`), 0644)
+	watcher.lock.Lock()
+	conn := watcher.files[filepath].conns[0]
+	watcher.lock.Unlock()
+	conn.Trigger(write_success)
+
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Errorf("Error reading websocket connection: %s", err)
+	}
+	if msg.Type != content_message {
+		t.Errorf("got %s; want %s", msg.Type, content_message)
 	}
 }
 
@@ -303,3 +354,90 @@ func TestTriggerErrorOnWatch(t *testing.T) {
 		}
 	}
 }
+
+func TestReloadImagesOnWatch(t *testing.T) {
+	image, _ := os.CreateTemp(".", "*.png")
+	defer os.Remove(image.Name())
+	imagepath := image.Name()[2:]
+
+	file, _ := os.CreateTemp(".", "*")
+	defer os.Remove(file.Name())
+	info, _ := file.Stat()
+	filepath := file.Name()[2:]
+
+	watcher := newFileWatcher(true)
+	// Setup fake conn struct referencing the image.
+	c := &conn{
+		Ch:       make(chan string),
+		ImagesCh: make(chan []string, 1),
+	}
+	c.SetImages([]string{imagepath})
+	watcher.files[filepath] = &connCluster{
+		Lastmodifed: info.ModTime(),
+		conns:       []*conn{c},
+	}
+
+	watcher.harness.useWaitGroup = true
+	watcher.watchInv = 0 // No delay between each loop.
+	watcher.harness.wg.Add(1)
+	watcher.Watch()
+	watcher.harness.wg.Wait() // wait for goroutine to start.
+
+	// Space out the write time, otherwise, the difference in
+	// time may be negligible.
+	time.Sleep(30 * time.Millisecond)
+	image.WriteString("new image contents")
+
+	images := <-c.ImagesCh
+	if len(images) != 1 || images[0] != "/"+imagepath {
+		t.Errorf("got %v; want [/%s]", images, imagepath)
+	}
+}
+
+func TestReloadImagesDoesNotBlockWatcher(t *testing.T) {
+	first, _ := os.CreateTemp(".", "*.png")
+	defer os.Remove(first.Name())
+	second, _ := os.CreateTemp(".", "*.png")
+	defer os.Remove(second.Name())
+
+	file, _ := os.CreateTemp(".", "*")
+	defer os.Remove(file.Name())
+	info, _ := file.Stat()
+	filepath := file.Name()[2:]
+
+	watcher := newFileWatcher(true)
+	// Nothing receives from the connection, e.g. its tab just closed.
+	c := newConn(&MockWebsocketConn{})
+	c.SetImages([]string{first.Name()[2:], second.Name()[2:]})
+	watcher.files[filepath] = &connCluster{
+		Lastmodifed: info.ModTime(),
+		conns:       []*conn{c},
+	}
+
+	watcher.harness.useWaitGroup = true
+	watcher.watchInv = 0
+	watcher.harness.wg.Add(1)
+	watcher.Watch()
+	defer watcher.stopWatching()
+	watcher.harness.wg.Wait()
+
+	time.Sleep(30 * time.Millisecond)
+	first.WriteString("new image contents")
+	time.Sleep(30 * time.Millisecond)
+	second.WriteString("new image contents")
+	time.Sleep(30 * time.Millisecond)
+
+	counted := make(chan map[string]int)
+	go func() { counted <- watcher.ConnCounts() }()
+	select {
+	case <-counted:
+	case <-time.After(time.Second):
+		t.Fatal("got watcher blocked; want the lock released")
+	}
+
+	// Both reloads are kept until received.
+	images := <-c.ImagesCh
+	if len(images) != 2 {
+		t.Errorf("got %v; want both images", images)
+	}
+}
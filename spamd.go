@@ -2,9 +2,10 @@ package main
 
 import (
 	"os"
-	"os/signal"
 
+	"spamd/internal/logging"
 	"spamd/internal/options"
+	"spamd/internal/sys"
 	"spamd/service"
 )
 
@@ -13,19 +14,21 @@ const (
 )
 
 func main() {
-	closeOnCtrlC()
-	service.Run(options.ParseOptions(), version)
-}
-
-func closeOnCtrlC() {
-
-	var interrupt = make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	opts := options.ParseOptions()
+	if err := logging.Setup(os.Stderr, opts.LogLevel, opts.LogFormat); err != nil {
+		sys.ErrorAndExit(err.Error())
+	}
 
-	go func() {
-		<-interrupt
-		// Close all websocket connections before exiting.
-		service.Shutdown()
-		os.Exit(1)
-	}()
+	switch opts.Command {
+	case options.ExportCommand:
+		service.Export(opts)
+	case options.RenderCommand:
+		service.Render(opts)
+	case options.ListCommand:
+		service.List(opts)
+	case options.StopCommand:
+		service.Stop(opts)
+	default:
+		service.Run(opts, version)
+	}
 }